@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/text/unicode/norm"
+	"strings"
+	"unicode"
+)
+
+// A term produced by analysis, where it sits in the text counted in words, and the bytes of the text it
+// came from. Filters that drop a token leave the positions of the rest alone, so a phrase never matches
+// across a removed word.
+type token struct {
+	Text     string
+	Position int
+	Start    int
+	End      int
+}
+
+type Tokenizer interface {
+	Tokenize(text string) []token
+}
+
+type TokenFilter interface {
+	Filter(tokens []token) []token
+}
+
+// Turns text into the terms stored in and looked up from the index. The same analyzer must be used
+// for pages and queries or their terms will not line up.
+type Analyzer interface {
+	Analyze(text string) []token
+}
+
+// A tokenizer followed by filters applied in order
+type pipelineAnalyzer struct {
+	tokenizer Tokenizer
+	filters   []TokenFilter
+}
+
+func (analyzer pipelineAnalyzer) Analyze(text string) []token {
+	tokens := analyzer.tokenizer.Tokenize(text)
+	for _, filter := range analyzer.filters {
+		tokens = filter.Filter(tokens)
+	}
+	return tokens
+}
+
+// The analyzer chosen in config, used for pages in languages without their own. Set once at startup.
+var textAnalyzer Analyzer = newAnalyzer("standard", nil)
+
+// Lucene's classic English stop words
+var englishStopWords = []string{
+	"a", "an", "and", "are", "as", "at", "be", "but", "by", "for", "if", "in", "into", "is", "it", "no", "not",
+	"of", "on", "or", "such", "that", "the", "their", "then", "there", "these", "they", "this", "to", "was", "will", "with",
+}
+
+var germanStopWords = []string{
+	"aber", "als", "am", "an", "auch", "auf", "aus", "bei", "bin", "bis", "da", "dann", "das", "dass", "dem", "den",
+	"der", "des", "die", "dies", "diese", "dieser", "du", "durch", "ein", "eine", "einem", "einen", "einer", "eines",
+	"er", "es", "für", "hat", "hatte", "ich", "ihr", "im", "in", "ist", "ja", "kann", "man", "mit", "nach", "nicht",
+	"noch", "nur", "oder", "sich", "sie", "sind", "so", "um", "und", "uns", "von", "vor", "war", "was", "weil", "wenn",
+	"wie", "wir", "wird", "zu", "zum", "zur",
+}
+
+var spanishStopWords = []string{
+	"a", "al", "algo", "como", "con", "de", "del", "el", "él", "ella", "ellos", "en", "entre", "era", "es", "esta",
+	"este", "esto", "fue", "ha", "hay", "la", "las", "le", "les", "lo", "los", "más", "me", "mi", "muy", "ni", "no",
+	"nos", "o", "para", "pero", "por", "que", "se", "si", "sin", "sobre", "su", "sus", "también", "te", "tu", "un",
+	"una", "uno", "y", "ya", "yo",
+}
+
+// The stemmer and default stop words of each language analyzer
+var analyzerStemmers = map[string]func(string) string{"english": porterStem, "german": germanStem, "spanish": spanishStem}
+var analyzerStopWords = map[string][]string{"english": englishStopWords, "german": germanStopWords, "spanish": spanishStopWords}
+
+// Builds an analyzer by name: standard splits, lowercases and folds accents, and english, german and spanish
+// also drop the language's stop words and stem. stopWords replaces the language's list when not nil.
+func newAnalyzer(name string, stopWords []string) Analyzer {
+	if name == "" || name == "standard" {
+		return pipelineAnalyzer{unicodeTokenizer{}, []TokenFilter{lowercaseFilter{}, foldFilter{}}}
+	}
+	stem, ok := analyzerStemmers[name]
+	if !ok {
+		return nil
+	}
+	if stopWords == nil {
+		stopWords = analyzerStopWords[name]
+	}
+	return pipelineAnalyzer{unicodeTokenizer{}, []TokenFilter{lowercaseFilter{}, foldFilter{}, newStopFilter(stopWords), stemFilter{stem}}}
+}
+
+// Sets up the default analyzer and one for each language in config. A language whose analyzer is the
+// default shares it, stop words and all.
+func configureAnalyzer() error {
+	analyzer := newAnalyzer(configuration.Analyzer, configuration.StopWords)
+	if analyzer == nil {
+		return fmt.Errorf("Unknown analyzer %q", configuration.Analyzer)
+	}
+	languages := make(map[string]Analyzer, len(configuration.Languages))
+	for _, language := range configuration.Languages {
+		name, ok := languageAnalyzerNames[language]
+		if !ok {
+			return fmt.Errorf("No analyzer for language %q", language)
+		}
+		if name == configuration.Analyzer {
+			languages[language] = analyzer
+		} else {
+			languages[language] = newAnalyzer(name, nil)
+		}
+	}
+	textAnalyzer = analyzer
+	languageAnalyzers = languages
+	return nil
+}
+
+// Analyzes words already split on whitespace, as getWordsFromBody returns them. An empty word marks a
+// break, such as between two headings, that a phrase never matches across. The tokens have no offsets,
+// as there is no one text for them to point into.
+func analyzeWords(analyzer Analyzer, words []string) []token {
+	var tokens []token
+	offset := 0
+	start := 0
+	for end := 0; end <= len(words); end++ {
+		if end < len(words) && words[end] != "" {
+			continue
+		}
+		for _, term := range analyzer.Analyze(strings.Join(words[start:end], " ")) {
+			tokens = append(tokens, token{Text: term.Text, Position: offset + term.Position})
+		}
+		if len(tokens) > 0 {
+			offset = tokens[len(tokens)-1].Position + 2
+		}
+		start = end + 1
+	}
+	return tokens
+}
+
+func countTokens(tokens []token) map[string]int {
+	counts := make(map[string]int)
+	for _, token := range tokens {
+		counts[token.Text]++
+	}
+	return counts
+}
+
+func tokenPositions(tokens []token) map[string][]int {
+	positions := make(map[string][]int)
+	for _, token := range tokens {
+		positions[token.Text] = append(positions[token.Text], token.Position)
+	}
+	return positions
+}
+
+// Splits text into words at Unicode word boundaries, loosely following UAX #29. Letters, digits and
+// underscores make up words, with apostrophes and full stops allowed inside them (don't, node.js) and
+// commas and full stops inside numbers (3.14, 1,000). A short word may end in + or # (C++, C#).
+// Chinese and Japanese kana and ideographs, which are written without spaces, become a word each.
+type unicodeTokenizer struct{}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || r == '_'
+}
+
+func isIdeographic(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana)
+}
+
+func (unicodeTokenizer) Tokenize(text string) []token {
+	var tokens []token
+	var runes []rune
+	var offsets []int
+	for offset, r := range text {
+		runes = append(runes, r)
+		offsets = append(offsets, offset)
+	}
+	offsets = append(offsets, len(text))
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		if isIdeographic(r) {
+			tokens = append(tokens, token{string(r), len(tokens), offsets[i], offsets[i+1]})
+			i++
+			continue
+		}
+		if !isWordRune(r) {
+			i++
+			continue
+		}
+
+		end := i
+		for end < len(runes) {
+			if isWordRune(runes[end]) && !isIdeographic(runes[end]) {
+				end++
+				continue
+			}
+			//Punctuation joins a word only with word characters on both sides
+			if end+1 < len(runes) && isWordRune(runes[end+1]) && !isIdeographic(runes[end+1]) {
+				switch runes[end] {
+				case '\'', '’', '.':
+					end++
+					continue
+				case ',':
+					if unicode.IsDigit(runes[end-1]) && unicode.IsDigit(runes[end+1]) {
+						end++
+						continue
+					}
+				}
+			}
+			break
+		}
+		if end-i <= 3 && end < len(runes) && (runes[end] == '+' || runes[end] == '#') {
+			suffix := end
+			for suffix < len(runes) && runes[suffix] == runes[end] {
+				suffix++
+			}
+			if suffix == len(runes) || !isWordRune(runes[suffix]) {
+				end = suffix
+			}
+		}
+		tokens = append(tokens, token{string(runes[i:end]), len(tokens), offsets[i], offsets[end]})
+		i = end
+	}
+	return tokens
+}
+
+type lowercaseFilter struct{}
+
+func (lowercaseFilter) Filter(tokens []token) []token {
+	for i := range tokens {
+		tokens[i].Text = strings.ToLower(tokens[i].Text)
+	}
+	return tokens
+}
+
+// Folds accented Latin letters and compatibility characters to their plain forms (café to cafe, ﬁ to fi)
+// and removes apostrophes, so don't and dont are one term. Marks on other scripts are kept, as they
+// often make a different letter rather than an accented one.
+type foldFilter struct{}
+
+var foldReplacer = strings.NewReplacer("ß", "ss", "æ", "ae", "œ", "oe", "ø", "o", "đ", "d", "ł", "l", "þ", "th", "'", "", "’", "")
+
+func foldText(text string) string {
+	var folded strings.Builder
+	var base rune
+	for _, r := range norm.NFKD.String(text) {
+		if !unicode.Is(unicode.Mn, r) {
+			base = r
+		} else if unicode.Is(unicode.Latin, base) {
+			continue
+		}
+		folded.WriteRune(r)
+	}
+	return norm.NFC.String(foldReplacer.Replace(folded.String()))
+}
+
+func (foldFilter) Filter(tokens []token) []token {
+	kept := tokens[:0]
+	for _, token := range tokens {
+		if token.Text = foldText(token.Text); token.Text != "" {
+			kept = append(kept, token)
+		}
+	}
+	return kept
+}
+
+type stopFilter struct {
+	words map[string]bool
+}
+
+// Stop words are folded like the text they are compared with
+func newStopFilter(words []string) stopFilter {
+	filter := stopFilter{make(map[string]bool, len(words))}
+	for _, word := range words {
+		filter.words[foldText(strings.ToLower(word))] = true
+	}
+	return filter
+}
+
+func (filter stopFilter) Filter(tokens []token) []token {
+	kept := tokens[:0]
+	for _, token := range tokens {
+		if !filter.words[token.Text] {
+			kept = append(kept, token)
+		}
+	}
+	return kept
+}
+
+type stemFilter struct {
+	stem func(string) string
+}
+
+func (filter stemFilter) Filter(tokens []token) []token {
+	for i := range tokens {
+		tokens[i].Text = filter.stem(tokens[i].Text)
+	}
+	return tokens
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func analyzedTerms(analyzer Analyzer, text string) []string {
+	var terms []string
+	for _, token := range analyzer.Analyze(text) {
+		terms = append(terms, token.Text)
+	}
+	return terms
+}
+
+func TestStandardAnalyzer(t *testing.T) {
+	analyzer := newAnalyzer("standard", nil)
+	fixtures := []struct {
+		text  string
+		terms []string
+	}{
+		{"Naïve café", []string{"naive", "cafe"}},
+		{"Straße Ærø", []string{"strasse", "aero"}},
+		{"x86 and C++ or C#, 1+1", []string{"x86", "and", "c++", "or", "c#", "1", "1"}},
+		{"don't Don’t dont", []string{"dont", "dont", "dont"}},
+		{"node.js costs 1,000.50.", []string{"node.js", "costs", "1,000.50"}},
+		{"x-ray, (brackets)", []string{"x", "ray", "brackets"}},
+		{"東京タワー", []string{"東", "京", "タ", "ワ", "ー"}},
+		{"Go言語", []string{"go", "言", "語"}},
+		{"Привет мир", []string{"привет", "мир"}},
+		{"ﬁle", []string{"file"}},
+		{" -- ", nil},
+	}
+	for _, fixture := range fixtures {
+		if terms := analyzedTerms(analyzer, fixture.text); !reflect.DeepEqual(terms, fixture.terms) {
+			t.Errorf("Expected %q to analyze to %q but received %q", fixture.text, fixture.terms, terms)
+		}
+	}
+}
+
+func TestEnglishAnalyzer(t *testing.T) {
+	analyzer := newAnalyzer("english", nil)
+	tokens := analyzer.Analyze("The runner is running in the runs")
+	expected := []token{{"runner", 1, 4, 10}, {"run", 3, 14, 21}, {"run", 6, 29, 33}}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("Expected %v but received %v", expected, tokens)
+	}
+
+	custom := newAnalyzer("english", []string{"Runner"})
+	if terms := analyzedTerms(custom, "the runner"); !reflect.DeepEqual(terms, []string{"the"}) {
+		t.Error("Expected custom stop words to replace the defaults but received", terms)
+	}
+}
+
+func TestConfigureAnalyzer(t *testing.T) {
+	defer func() {
+		configuration.Analyzer = ""
+		configuration.StopWords = nil
+		textAnalyzer = newAnalyzer("standard", nil)
+	}()
+
+	configuration.Analyzer = "klingon"
+	if err := configureAnalyzer(); err == nil {
+		t.Error("Expected an unknown analyzer to be rejected")
+	}
+	configuration.Analyzer = "english"
+	if err := configureAnalyzer(); err != nil {
+		t.Fatal(err)
+	}
+	if terms := analyzedTerms(textAnalyzer, "the connections"); !reflect.DeepEqual(terms, []string{"connect"}) {
+		t.Error("Expected the english analyzer to be used but received", terms)
+	}
+}
+
+func TestAnalyzeWords(t *testing.T) {
+	tokens := analyzeWords(textAnalyzer, []string{"Worker", "Pools", "", "Channels", "", "", "--", "", "Go"})
+	expected := []token{{Text: "worker", Position: 0}, {Text: "pools", Position: 1}, {Text: "channels", Position: 3}, {Text: "go", Position: 5}}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("Expected %v but received %v", expected, tokens)
+	}
+}
+
+func TestEnglishSearch(t *testing.T) {
+	clearIndex()
+	defer clearIndex()
+	textAnalyzer = newAnalyzer("english", nil)
+	defer func() { textAnalyzer = newAnalyzer("standard", nil) }()
+
+	running := indexCacheInfo{Title: "Running", URL: "http://example.com/running"}
+	indexTestDocument(running, "Running the worker pools")
+	fixtures := []struct {
+		query  string
+		result []indexCacheInfo
+	}{
+		{"runs", []indexCacheInfo{running}},
+		{"RUN", []indexCacheInfo{running}},
+		{"\"workers pool\"", []indexCacheInfo{running}},
+		{"\"run worker\"", nil},
+		{"\"running a worker\"", []indexCacheInfo{running}},
+		{"\"running of the worker\"", nil},
+		{"\"worker running\"", nil},
+		{"the runs", []indexCacheInfo{running}},
+	}
+	for _, fixture := range fixtures {
+		node, err := parseQuery(fixture.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result []indexCacheInfo
+		for _, pair := range searchQuery(node) {
+			result = append(result, pair.Title)
+		}
+		if !reflect.DeepEqual(result, fixture.result) {
+			t.Errorf("Expected %q to find %v but received %v", fixture.query, fixture.result, result)
+		}
+	}
+
+	if _, err := parseQuery("the"); err == nil {
+		t.Error("Expected a query of only stop words to be rejected")
+	}
+}
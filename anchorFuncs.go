@@ -0,0 +1,190 @@
+package main
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"sort"
+	"strings"
+)
+
+// A link found on a page and the text it was written with
+type pageLink struct {
+	URL  string
+	Text string
+}
+
+// The links each indexed page makes, keyed by the page's canonical URL, and the same links turned around:
+// the anchor text pointing at each target, keyed by target then source, and every link's text (blank
+// ones too) keyed the same way for listing a page's inbound links. Guarded by indexCashMutex.
+var outboundLinks = map[string][]pageLink{}
+var inboundAnchors = map[string]map[string]string{}
+var inboundLinks = map[string]map[string][]string{}
+
+// Anchor field words currently indexed for each document, so they can be taken out again when a link changes
+var anchorTerms = map[indexCacheInfo]map[string]int{}
+
+func getAnchorsFromDocument(document *goquery.Document) []pageLink {
+	var links []pageLink
+	document.Find("a").Each(func(i int, s *goquery.Selection) {
+		href, exists := s.Attr("href")
+		if exists {
+			links = append(links, pageLink{href, strings.Join(strings.Fields(s.Text()), " ")})
+		}
+	})
+	return links
+}
+
+// Resolves a page's links against its final URL and canonicalizes them so they name the documents they
+// will be indexed as. Links that cannot be resolved are dropped.
+func canonicalLinks(links []pageLink, base string) []pageLink {
+	var canonical []pageLink
+	for _, link := range links {
+		absolute, err := formatURL(link.URL, base)
+		if err != nil {
+			continue
+		}
+		URL, err := canonicalURL(absolute)
+		if err != nil {
+			continue
+		}
+		canonical = append(canonical, pageLink{URL, link.Text})
+	}
+	return canonical
+}
+
+// Replaces the links a page makes and re-indexes the anchor text of every page they pointed or now point at
+func applyLinks(source string, links []pageLink) {
+	affected := map[string]bool{}
+	for _, link := range outboundLinks[source] {
+		affected[link.URL] = true
+		delete(inboundAnchors[link.URL], source)
+		if len(inboundAnchors[link.URL]) == 0 {
+			delete(inboundAnchors, link.URL)
+		}
+		delete(inboundLinks[link.URL], source)
+		if len(inboundLinks[link.URL]) == 0 {
+			delete(inboundLinks, link.URL)
+		}
+	}
+	delete(outboundLinks, source)
+
+	if len(links) > 0 {
+		outboundLinks[source] = links
+	}
+	for _, link := range links {
+		addInboundLink(source, link)
+		if addInboundAnchor(source, link) {
+			affected[link.URL] = true
+		}
+	}
+	for target := range affected {
+		applyAnchorField(target)
+	}
+}
+
+// Records the link against its target so the target's inbound links need not be searched for
+func addInboundLink(source string, link pageLink) {
+	if link.URL == source {
+		return
+	}
+	if inboundLinks[link.URL] == nil {
+		inboundLinks[link.URL] = make(map[string][]string)
+	}
+	inboundLinks[link.URL][source] = append(inboundLinks[link.URL][source], link.Text)
+}
+
+// Records the link's text against its target, joining it to any other links from the same page.
+// Links back to the page itself are navigation rather than a description of it, so are skipped.
+func addInboundAnchor(source string, link pageLink) bool {
+	if link.URL == source || link.Text == "" {
+		return false
+	}
+	if inboundAnchors[link.URL] == nil {
+		inboundAnchors[link.URL] = make(map[string]string)
+	}
+	if text, ok := inboundAnchors[link.URL][source]; ok {
+		inboundAnchors[link.URL][source] = text + " " + link.Text
+	} else {
+		inboundAnchors[link.URL][source] = link.Text
+	}
+	return true
+}
+
+// Indexes the anchor text other pages use for the document at URL under its anchor field.
+// Texts from different pages are joined in source order, separated so a phrase never spans two of them.
+func applyAnchorField(URL string) {
+	info, ok := documentsByURL[URL]
+	if !ok {
+		return
+	}
+	for term := range anchorTerms[info] {
+		delete(indexCache[term], info)
+		if len(indexCache[term]) == 0 {
+			delete(indexCache, term)
+		}
+		delete(termPositions[term], info)
+		if len(termPositions[term]) == 0 {
+			delete(termPositions, term)
+		}
+	}
+	delete(anchorTerms, info)
+
+	sources := make([]string, 0, len(inboundAnchors[URL]))
+	for source := range inboundAnchors[URL] {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	var words []string
+	for i, source := range sources {
+		if i > 0 {
+			words = append(words, "")
+		}
+		words = append(words, strings.Fields(inboundAnchors[URL][source])...)
+	}
+
+	counts := make(map[string]int)
+	positions := make(map[string][]int)
+	addFieldWords(analyzerFor(documentLanguages[URL]), counts, positions, anchorField, words)
+	length := 0
+	for term, count := range counts {
+		if _, found := indexCache[term]; !found {
+			indexCache[term] = make(map[indexCacheInfo]int)
+		}
+		indexCache[term][info] = count
+		length += count
+	}
+	applyPositions(positions, info)
+	setDocumentLength(anchorField, info, length)
+	if len(counts) > 0 {
+		anchorTerms[info] = counts
+	}
+}
+
+// Loads links from a snapshot, whose postings already hold the anchor text they contributed
+func restoreLinks(links map[string][]pageLink) {
+	clearLinks()
+	for source, sourceLinks := range links {
+		outboundLinks[source] = sourceLinks
+		for _, link := range sourceLinks {
+			addInboundLink(source, link)
+			addInboundAnchor(source, link)
+		}
+	}
+	for term, documents := range indexCache {
+		if termField(term) != anchorField {
+			continue
+		}
+		for info, count := range documents {
+			if anchorTerms[info] == nil {
+				anchorTerms[info] = make(map[string]int)
+			}
+			anchorTerms[info][term] = count
+		}
+	}
+}
+
+func clearLinks() {
+	outboundLinks = make(map[string][]pageLink)
+	inboundAnchors = make(map[string]map[string]string)
+	inboundLinks = make(map[string]map[string][]string)
+	anchorTerms = make(map[indexCacheInfo]map[string]int)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestGetAnchorsFromBody(t *testing.T) {
+	fixtures := []struct {
+		body   string
+		result []pageLink
+	}{
+		{"<a href=\"/a\">Worker\n  <b>Pools</b></a>", []pageLink{{"/a", "Worker Pools"}}},
+		{"<a href=\"/a\"><img src=\"x.png\"></a><a>No Link</a>", []pageLink{{"/a", ""}}},
+		{"<p>none</p>", nil},
+	}
+	for _, fixture := range fixtures {
+		document, _ := parseBody(fixture.body)
+		if result := getAnchorsFromDocument(document); !reflect.DeepEqual(result, fixture.result) {
+			t.Errorf("Expected %q to have anchors %v but received %v", fixture.body, fixture.result, result)
+		}
+	}
+}
+
+func TestCrawlIndexesAnchorText(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			http.NotFound(w, r)
+		case "/start":
+			fmt.Fprint(w, "<a href=\"/about?utm_source=nav\">Kubernetes Handbook</a><a href=\"/start\">Home page</a>")
+		default:
+			fmt.Fprint(w, "<title>About</title>container orchestration notes")
+		}
+	}))
+	defer server.Close()
+
+	configuration.MaxDepth = 2
+	configuration.MaxParallel = 1
+	configuration.TrackingParams = []string{"utm_*"}
+	defer func() { configuration.TrackingParams = nil }()
+	clearIndex()
+	defer clearIndex()
+	runCrawlJob(newCrawlJob(crawlRequest{URL: server.URL + "/start"}))
+
+	query, _ := parseQuery("anchor:handbook")
+	results := searchQuery(query)
+	if len(results) != 1 || results[0].Title.URL != server.URL+"/about" {
+		t.Error("Expected the linked page to be found by its anchor text but received", results)
+	}
+	//The word is not on the page, but an unscoped search still finds it
+	found := false
+	for _, result := range searchIndexForWord("handbook") {
+		found = found || result.Title.URL == server.URL+"/about"
+	}
+	if !found {
+		t.Error("Expected anchor text to match unscoped searches")
+	}
+	if len(indexCache["anchor:home"]) != 0 {
+		t.Error("Expected links to the page itself to be ignored but found", indexCache["anchor:home"])
+	}
+}
+
+func TestAnchorTextFollowsLinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kgp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	clearIndex()
+	defer clearIndex()
+
+	target := indexCacheInfo{"Target", "test.com/target"}
+	source := indexCacheInfo{"Source", "test.com/source"}
+	other := indexCacheInfo{"Other", "test.com/other"}
+	replaceDocument(walRecord{Info: target, Counts: map[string]int{"page": 1}})
+	replaceDocument(walRecord{Info: source, Counts: map[string]int{"page": 1}, Links: []pageLink{{target.URL, "Worker Pools"}, {target.URL, "guide"}}})
+	replaceDocument(walRecord{Info: other, Counts: map[string]int{"page": 1}, Links: []pageLink{{target.URL, "worker threads"}}})
+
+	expected := map[string]int{"anchor:worker": 2, "anchor:pools": 1, "anchor:guide": 1, "anchor:threads": 1}
+	if !reflect.DeepEqual(anchorTerms[target], expected) {
+		t.Error("Expected anchor text from both pages but found", anchorTerms[target])
+	}
+	query, _ := parseQuery("anchor:\"worker pools\"")
+	if results := searchQuery(query); len(results) != 1 || results[0].Title != target {
+		t.Error("Expected the anchor phrase to find the target but received", results)
+	}
+	//Texts from different pages do not run together into a phrase
+	query, _ = parseQuery("anchor:\"guide worker\"")
+	if results := searchQuery(query); len(results) != 0 {
+		t.Error("Expected no phrase across two linking pages but received", results)
+	}
+
+	//Re-indexing the target keeps what others say about it, and the anchor text survives a restart
+	replaceDocument(walRecord{Info: target, Counts: map[string]int{"page": 2}})
+	if err := saveSnapshot(dir); err != nil {
+		t.Fatal(err)
+	}
+	clearIndex()
+	if _, err := loadSnapshot(dir); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(anchorTerms[target], expected) {
+		t.Error("Expected anchor text to be restored but found", anchorTerms[target])
+	}
+
+	//Dropping the link from the source leaves only the other page's text
+	replaceDocument(walRecord{Info: source, Counts: map[string]int{"page": 1}})
+	removeDocument(other)
+	if len(anchorTerms[target]) != 0 || len(indexCache["anchor:worker"]) != 0 || len(documentLengths[anchorField]) != 0 {
+		t.Error("Expected the anchor text to go with the links but found", anchorTerms[target], indexCache["anchor:worker"])
+	}
+}
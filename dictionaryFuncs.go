@@ -0,0 +1,263 @@
+package main
+
+import (
+	"container/heap"
+	"sort"
+	"strings"
+)
+
+const defaultSuggestLimit = 10
+const maxSuggestLimit = 100
+
+// Body terms in sorted order with the number of documents each appears in, kept in step with indexCache
+// so terms can be found by prefix. Guarded by indexCashMutex.
+var dictionary = newTermDictionary()
+
+// Words as they are written on pages, lowercased and folded but not stemmed, with the number of documents
+// each appears in. Completions and spelling suggestions come from here, as nobody types a stem.
+// Guarded by indexCashMutex.
+var wordDictionary = newTermDictionary()
+
+// The distinct words of each page counted in wordDictionary, keyed by URL, so they can be taken out again
+var documentWords = map[string][]string{}
+
+// Splits, lowercases and folds like every analyzer does before dropping stop words and stemming
+var surfaceAnalyzer = newAnalyzer("standard", nil)
+
+// A radix tree of terms. Each node knows the highest document frequency below it, so the most common
+// completions of a prefix are found without visiting the rest.
+type termDictionary struct {
+	root *dictionaryNode
+}
+
+type dictionaryNode struct {
+	//The part of the term on the way to this node, and the document frequency of the term ending here, if any
+	edge     string
+	weight   int
+	best     int
+	children []*dictionaryNode
+}
+
+type completion struct {
+	Term      string
+	Documents int
+}
+
+func newTermDictionary() *termDictionary {
+	return &termDictionary{root: &dictionaryNode{}}
+}
+
+// Sets the number of documents a term appears in, removing it at 0
+func (dictionary *termDictionary) set(term string, documents int) {
+	dictionary.root.set(term, documents)
+}
+
+// The index of the child whose edge starts with the byte, or where one would go
+func (node *dictionaryNode) child(b byte) (int, bool) {
+	low, high := 0, len(node.children)
+	for low < high {
+		middle := (low + high) / 2
+		if node.children[middle].edge[0] < b {
+			low = middle + 1
+		} else {
+			high = middle
+		}
+	}
+	return low, low < len(node.children) && node.children[low].edge[0] == b
+}
+
+func commonPrefixLength(a string, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+func (node *dictionaryNode) set(rest string, weight int) {
+	if rest == "" {
+		node.weight = weight
+		node.refresh()
+		return
+	}
+
+	i, found := node.child(rest[0])
+	if !found {
+		if weight == 0 {
+			return
+		}
+		leaf := &dictionaryNode{edge: rest, weight: weight, best: weight}
+		node.children = append(node.children, nil)
+		copy(node.children[i+1:], node.children[i:])
+		node.children[i] = leaf
+		node.refresh()
+		return
+	}
+	child := node.children[i]
+	common := commonPrefixLength(child.edge, rest)
+	if common < len(child.edge) {
+		if weight == 0 {
+			return
+		}
+		//The term leaves the edge part way along, so the edge is split where they part
+		split := &dictionaryNode{edge: child.edge[:common], best: child.best, children: []*dictionaryNode{child}}
+		child.edge = child.edge[common:]
+		node.children[i] = split
+		child = split
+	}
+	child.set(rest[common:], weight)
+
+	//Nodes that no longer end a term or branch are folded back into their neighbours
+	if child.weight == 0 && len(child.children) == 0 {
+		node.children = append(node.children[:i], node.children[i+1:]...)
+	} else if child.weight == 0 && len(child.children) == 1 {
+		grandchild := child.children[0]
+		grandchild.edge = child.edge + grandchild.edge
+		node.children[i] = grandchild
+	}
+	node.refresh()
+}
+
+func (node *dictionaryNode) refresh() {
+	node.best = node.weight
+	for _, child := range node.children {
+		if child.best > node.best {
+			node.best = child.best
+		}
+	}
+}
+
+// A term or a subtree waiting to be visited, ordered by document frequency then alphabetically.
+// Every term below a subtree starts with its text, so a subtree is always visited before its terms.
+type completionCandidate struct {
+	text   string
+	weight int
+	node   *dictionaryNode
+}
+
+type completionQueue []completionCandidate
+
+func (queue completionQueue) Len() int { return len(queue) }
+func (queue completionQueue) Less(i, j int) bool {
+	if queue[i].weight != queue[j].weight {
+		return queue[i].weight > queue[j].weight
+	}
+	return queue[i].text < queue[j].text
+}
+func (queue completionQueue) Swap(i, j int)       { queue[i], queue[j] = queue[j], queue[i] }
+func (queue *completionQueue) Push(x interface{}) { *queue = append(*queue, x.(completionCandidate)) }
+func (queue *completionQueue) Pop() interface{} {
+	old := *queue
+	candidate := old[len(old)-1]
+	*queue = old[:len(old)-1]
+	return candidate
+}
+
+// Returns up to limit terms starting with the prefix, most documents first
+func (dictionary *termDictionary) complete(prefix string, limit int) []completion {
+	node := dictionary.root
+	text := ""
+	rest := prefix
+	for rest != "" {
+		i, found := node.child(rest[0])
+		if !found {
+			return []completion{}
+		}
+		child := node.children[i]
+		common := commonPrefixLength(child.edge, rest)
+		if common < len(rest) && common < len(child.edge) {
+			return []completion{}
+		}
+		text += child.edge
+		rest = rest[common:]
+		node = child
+	}
+
+	completions := []completion{}
+	queue := &completionQueue{{text, node.best, node}}
+	for queue.Len() > 0 && len(completions) < limit {
+		candidate := heap.Pop(queue).(completionCandidate)
+		if candidate.node == nil {
+			completions = append(completions, completion{candidate.text, candidate.weight})
+			continue
+		}
+		if candidate.node.weight > 0 {
+			heap.Push(queue, completionCandidate{candidate.text, candidate.node.weight, nil})
+		}
+		for _, child := range candidate.node.children {
+			heap.Push(queue, completionCandidate{candidate.text + child.edge, child.best, child})
+		}
+	}
+	return completions
+}
+
+// Builds the dictionary for an index loaded in one go
+func buildTermDictionary(index map[string]map[indexCacheInfo]int) *termDictionary {
+	built := newTermDictionary()
+	for term, documents := range index {
+		if termField(term) == bodyField {
+			built.set(term, len(documents))
+		}
+	}
+	return built
+}
+
+// The distinct words of a text that the analyzer indexes, as written rather than as the terms they become.
+// Words the analyzer drops, such as stop words, are left out.
+func surfaceWords(analyzer Analyzer, text string) []string {
+	indexed := make(map[int]bool)
+	for _, term := range analyzer.Analyze(text) {
+		indexed[term.Position] = true
+	}
+	seen := make(map[string]bool)
+	var words []string
+	for _, word := range surfaceAnalyzer.Analyze(text) {
+		if indexed[word.Position] && !seen[word.Text] {
+			seen[word.Text] = true
+			words = append(words, word.Text)
+		}
+	}
+	sort.Strings(words)
+	return words
+}
+
+// Counts a page's words in the word dictionary in place of any counted for it before. Callers must hold indexCashMutex.
+func setDocumentWords(URL string, words []string) {
+	for _, word := range documentWords[URL] {
+		wordDictionary.set(word, wordDictionary.documents(word)-1)
+	}
+	delete(documentWords, URL)
+	for _, word := range words {
+		wordDictionary.set(word, wordDictionary.documents(word)+1)
+	}
+	if len(words) > 0 {
+		documentWords[URL] = words
+	}
+}
+
+// Builds the word dictionary for pages loaded in one go
+func buildWordDictionary(words map[string][]string) *termDictionary {
+	frequencies := make(map[string]int)
+	for _, pageWords := range words {
+		for _, word := range pageWords {
+			frequencies[word]++
+		}
+	}
+	built := newTermDictionary()
+	for word, documents := range frequencies {
+		built.set(word, documents)
+	}
+	return built
+}
+
+// Completes the start of a word typed into a search box from the words written on pages. The prefix is
+// lowercased and folded like them.
+func suggest(prefix string, limit int) []completion {
+	prefix = foldText(strings.ToLower(strings.TrimSpace(prefix)))
+	if prefix == "" {
+		return []completion{}
+	}
+	indexCashMutex.RLock()
+	defer indexCashMutex.RUnlock()
+	return wordDictionary.complete(prefix, limit)
+}
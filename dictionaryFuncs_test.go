@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestTermDictionary(t *testing.T) {
+	terms := newTermDictionary()
+	for term, documents := range map[string]int{"go": 5, "golang": 3, "gopher": 3, "goroutine": 7, "grpc": 2, "rust": 9} {
+		terms.set(term, documents)
+	}
+	fixtures := []struct {
+		prefix      string
+		limit       int
+		completions []completion
+	}{
+		{"go", 10, []completion{{"goroutine", 7}, {"go", 5}, {"golang", 3}, {"gopher", 3}}},
+		{"go", 2, []completion{{"goroutine", 7}, {"go", 5}}},
+		{"gol", 10, []completion{{"golang", 3}}},
+		{"golang", 10, []completion{{"golang", 3}}},
+		{"golangs", 10, []completion{}},
+		{"x", 10, []completion{}},
+		{"", 3, []completion{{"rust", 9}, {"goroutine", 7}, {"go", 5}}},
+	}
+	for _, fixture := range fixtures {
+		if completions := terms.complete(fixture.prefix, fixture.limit); !reflect.DeepEqual(completions, fixture.completions) {
+			t.Errorf("Expected %q to complete to %v but received %v", fixture.prefix, fixture.completions, completions)
+		}
+	}
+
+	terms.set("goroutine", 0)
+	terms.set("go", 1)
+	terms.set("missing", 0)
+	expected := []completion{{"golang", 3}, {"gopher", 3}, {"go", 1}}
+	if completions := terms.complete("go", 10); !reflect.DeepEqual(completions, expected) {
+		t.Errorf("Expected updates to be reflected as %v but received %v", expected, completions)
+	}
+}
+
+func TestTermDictionaryMatchesSortedTerms(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	terms := newTermDictionary()
+	weights := map[string]int{}
+	for i := 0; i < 5000; i++ {
+		length := 1 + random.Intn(5)
+		letters := make([]byte, length)
+		for j := range letters {
+			letters[j] = "abc"[random.Intn(3)]
+		}
+		term := string(letters)
+		weight := random.Intn(4)
+		terms.set(term, weight)
+		if weight == 0 {
+			delete(weights, term)
+		} else {
+			weights[term] = weight
+		}
+	}
+
+	for _, prefix := range []string{"", "a", "ab", "abc", "cc", "bca"} {
+		var expected []completion
+		for term, weight := range weights {
+			if strings.HasPrefix(term, prefix) {
+				expected = append(expected, completion{term, weight})
+			}
+		}
+		sort.Slice(expected, func(i, j int) bool {
+			if expected[i].Documents != expected[j].Documents {
+				return expected[i].Documents > expected[j].Documents
+			}
+			return expected[i].Term < expected[j].Term
+		})
+		if len(expected) > 20 {
+			expected = expected[:20]
+		}
+		if completions := terms.complete(prefix, 20); !reflect.DeepEqual(completions, expected) {
+			t.Errorf("Expected %q to complete to %v but received %v", prefix, expected, completions)
+		}
+	}
+}
+
+func TestSuggestFollowsIndex(t *testing.T) {
+	clearIndex()
+	defer clearIndex()
+	indexTestDocument(indexCacheInfo{"Pools", "test.com/pools"}, "worker pools")
+	indexTestDocument(indexCacheInfo{"Threads", "test.com/threads"}, "worker threads and workflows")
+
+	expected := []completion{{"worker", 2}, {"workflows", 1}}
+	if completions := suggest(" WOR", 10); !reflect.DeepEqual(completions, expected) {
+		t.Errorf("Expected %v but received %v", expected, completions)
+	}
+
+	indexTestDocument(indexCacheInfo{"Threads", "test.com/threads"}, "green threads")
+	expected = []completion{{"worker", 1}}
+	if completions := suggest("wor", 10); !reflect.DeepEqual(completions, expected) {
+		t.Errorf("Expected a re-indexed page to drop its old words leaving %v but received %v", expected, completions)
+	}
+
+	indexCashMutex.Lock()
+	snapshot := buildSnapshot()
+	indexCashMutex.Unlock()
+	clearIndex()
+	if completions := suggest("wor", 10); len(completions) != 0 {
+		t.Error("Expected clearing the index to empty the dictionary but received", completions)
+	}
+	if err := restoreSnapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if completions := suggest("wor", 10); !reflect.DeepEqual(completions, expected) {
+		t.Errorf("Expected a restored index to rebuild %v but received %v", expected, completions)
+	}
+}
+
+func TestSuggestWrittenWords(t *testing.T) {
+	textAnalyzer = newAnalyzer("english", nil)
+	defer func() { textAnalyzer = newAnalyzer("standard", nil) }()
+	clearIndex()
+	defer clearIndex()
+	indexTestDocument(indexCacheInfo{"Computers", "test.com/computers"}, "The computer libraries compute")
+	indexTestDocument(indexCacheInfo{"Computing", "test.com/computing"}, "computing with a computer library")
+
+	fixtures := []struct {
+		prefix      string
+		completions []completion
+	}{
+		{"comp", []completion{{"computer", 2}, {"compute", 1}, {"computing", 1}}},
+		{"computer", []completion{{"computer", 2}}},
+		{"libr", []completion{{"libraries", 1}, {"library", 1}}},
+		{"th", []completion{}},
+	}
+	for _, fixture := range fixtures {
+		if completions := suggest(fixture.prefix, 10); !reflect.DeepEqual(completions, fixture.completions) {
+			t.Errorf("Expected %q to complete to %v but received %v", fixture.prefix, fixture.completions, completions)
+		}
+	}
+}
+
+func TestSuggestHandler(t *testing.T) {
+	clearIndex()
+	defer clearIndex()
+	indexTestDocument(indexCacheInfo{"Go", "test.com/go"}, "golang gopher goroutine")
+
+	fixtures := []struct {
+		URL   string
+		code  int
+		terms int
+	}{
+		{"/suggest?prefix=go", http.StatusOK, 3},
+		{"/suggest?prefix=go&limit=1", http.StatusOK, 1},
+		{"/suggest?prefix=zz", http.StatusOK, 0},
+		{"/suggest", http.StatusUnprocessableEntity, 0},
+		{"/suggest?prefix=go&limit=0", http.StatusUnprocessableEntity, 0},
+		{"/suggest?prefix=go&limit=many", http.StatusUnprocessableEntity, 0},
+	}
+	for _, fixture := range fixtures {
+		recorder := httptest.NewRecorder()
+		suggestHandler(recorder, httptest.NewRequest("GET", fixture.URL, nil))
+		var completions []completion
+		json.NewDecoder(recorder.Body).Decode(&completions)
+		if recorder.Code != fixture.code || len(completions) != fixture.terms {
+			t.Errorf("Expected %s to return %d with %d terms but received %d with %v", fixture.URL, fixture.code, fixture.terms, recorder.Code, completions)
+		}
+	}
+}
+
+func BenchmarkSuggest(b *testing.B) {
+	terms := newTermDictionary()
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000000; i++ {
+		terms.set(fmt.Sprintf("%x", random.Int63()), 1+random.Intn(1000))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		terms.complete(fmt.Sprintf("%x", i%16), defaultSuggestLimit)
+	}
+}
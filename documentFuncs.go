@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// What was fetched for a page besides its words
+type documentMetadata struct {
+	FinalURL    string
+	StatusCode  int
+	ContentType string
+	Fetched     time.Time
+	Size        int
+	//Validators for a conditional fetch, a checksum of the body and how long until the page is fetched again
+	ETag         string
+	LastModified string
+	Checksum     uint64
+	Interval     time.Duration
+}
+
+// Fetch metadata of each indexed page, keyed by canonical URL. Guarded by indexCashMutex.
+var documentStore = map[string]documentMetadata{}
+
+// The canonical URL of each indexed document, keyed by document ID. Guarded by indexCashMutex.
+var documentIDs = map[string]string{}
+
+// Everything known about an indexed page
+type documentRecord struct {
+	ID          string
+	URL         string
+	FinalURL    string
+	Title       string
+	StatusCode  int
+	ContentType string
+	Fetched     time.Time
+	NextFetch   *time.Time `json:",omitempty"`
+	Size        int
+	ETag        string `json:",omitempty"`
+	Language    string
+	Outlinks    []string
+}
+
+// A term indexed for a document, in one of its fields
+type documentTerm struct {
+	Field     string
+	Term      string
+	Count     int
+	Positions []int `json:",omitempty"`
+}
+
+// A link to or from a document, with the ID of the other end when it is indexed
+type documentLink struct {
+	ID   string `json:",omitempty"`
+	URL  string
+	Text string
+}
+
+type documentLinks struct {
+	ID       string
+	URL      string
+	PageRank float64
+	Inbound  []documentLink
+	Outbound []documentLink
+}
+
+// Documents are identified by a hash of their canonical URL, so an ID survives re-crawls and restarts
+func documentID(URL string) string {
+	hash := fnv.New64a()
+	hash.Write([]byte(URL))
+	return fmt.Sprintf("%016x", hash.Sum64())
+}
+
+// Finds the indexed document with the ID. Callers must hold indexCashMutex.
+func documentByID(id string) (indexCacheInfo, bool) {
+	info, ok := documentsByURL[documentIDs[id]]
+	return info, ok
+}
+
+// Rebuilds the document IDs for documents loaded in one go
+func buildDocumentIDs(documents map[string]indexCacheInfo) map[string]string {
+	ids := make(map[string]string, len(documents))
+	for URL := range documents {
+		ids[documentID(URL)] = URL
+	}
+	return ids
+}
+
+// Callers must hold indexCashMutex
+func linkTo(URL string, text string) documentLink {
+	link := documentLink{URL: URL, Text: text}
+	if _, ok := documentsByURL[URL]; ok {
+		link.ID = documentID(URL)
+	}
+	return link
+}
+
+// Returns the pages linking to and linked from the document with the ID
+func getDocumentLinks(id string) (documentLinks, bool) {
+	indexCashMutex.RLock()
+	defer indexCashMutex.RUnlock()
+	info, ok := documentByID(id)
+	if !ok {
+		return documentLinks{}, false
+	}
+
+	links := documentLinks{ID: id, URL: info.URL, PageRank: pageRanks[info.URL], Inbound: []documentLink{}, Outbound: []documentLink{}}
+	for _, link := range outboundLinks[info.URL] {
+		links.Outbound = append(links.Outbound, linkTo(link.URL, link.Text))
+	}
+	sources := make([]string, 0, len(inboundLinks[info.URL]))
+	for source := range inboundLinks[info.URL] {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		for _, text := range inboundLinks[info.URL][source] {
+			links.Inbound = append(links.Inbound, linkTo(source, text))
+		}
+	}
+	return links, true
+}
+
+// Returns the stored metadata of the document with the ID
+func getDocument(id string) (documentRecord, bool) {
+	indexCashMutex.RLock()
+	defer indexCashMutex.RUnlock()
+	info, ok := documentByID(id)
+	if !ok {
+		return documentRecord{}, false
+	}
+
+	metadata := documentStore[info.URL]
+	document := documentRecord{ID: id, URL: info.URL, FinalURL: metadata.FinalURL, Title: info.Title, StatusCode: metadata.StatusCode,
+		ContentType: metadata.ContentType, Fetched: metadata.Fetched, Size: metadata.Size, ETag: metadata.ETag,
+		Language: documentLanguages[info.URL], Outlinks: []string{}}
+	if metadata.Interval > 0 {
+		next := metadata.Fetched.Add(metadata.Interval)
+		document.NextFetch = &next
+	}
+	for _, link := range outboundLinks[info.URL] {
+		document.Outlinks = append(document.Outlinks, link.URL)
+	}
+	return document, true
+}
+
+// Returns the terms indexed for the document with the ID, by field then term
+func getDocumentTerms(id string) ([]documentTerm, bool) {
+	indexCashMutex.RLock()
+	defer indexCashMutex.RUnlock()
+	info, ok := documentByID(id)
+	if !ok {
+		return nil, false
+	}
+
+	terms := []documentTerm{}
+	for _, term := range indexedTerms(info) {
+		if count, ok := indexCache[term][info]; ok {
+			terms = append(terms, documentTerm{termField(term), termWord(term), count, termPositions[term][info]})
+		}
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].Field != terms[j].Field {
+			return terms[i].Field < terms[j].Field
+		}
+		return terms[i].Term < terms[j].Term
+	})
+	return terms, true
+}
+
+// Removes whatever is indexed for the URL, returning whether there was anything
+func removeURL(URL string) (bool, error) {
+	indexCashMutex.Lock()
+	defer indexCashMutex.Unlock()
+	info, ok := documentsByURL[URL]
+	if !ok {
+		return false, nil
+	}
+	return true, logAndApplyLocked([]walRecord{{Op: walRemove, Info: info}})
+}
+
+// Removes every document on the host. A host without a port matches the host on any port.
+// Returns the number of documents removed.
+func removeHost(host string) (int, error) {
+	host = strings.ToLower(host)
+	indexCashMutex.Lock()
+	defer indexCashMutex.Unlock()
+	var records []walRecord
+	for URL, info := range documentsByURL {
+		parsed, err := url.Parse(URL)
+		if err != nil {
+			continue
+		}
+		if strings.ToLower(parsed.Host) == host || strings.ToLower(parsed.Hostname()) == host {
+			records = append(records, walRecord{Op: walRemove, Info: info})
+		}
+	}
+	return len(records), logAndApplyLocked(records)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDocumentID(t *testing.T) {
+	if documentID("http://test.com/a") != documentID("http://test.com/a") {
+		t.Error("Expected the same URL to always have the same ID")
+	}
+	if documentID("http://test.com/a") == documentID("http://test.com/b") {
+		t.Error("Expected different URLs to have different IDs")
+	}
+	if len(documentID("http://test.com/a")) != 16 {
+		t.Error("Expected a 16 character ID but received", documentID("http://test.com/a"))
+	}
+}
+
+func TestGetDocumentLinks(t *testing.T) {
+	clearIndex()
+	defer clearIndex()
+	page := indexCacheInfo{"Page", "test.com/page"}
+	source := indexCacheInfo{"Source", "test.com/source"}
+	replaceDocument(walRecord{Info: page, Counts: map[string]int{"a": 1}, Links: []pageLink{{"test.com/unindexed", "elsewhere"}, {page.URL, "top"}}})
+	replaceDocument(walRecord{Info: source, Counts: map[string]int{"a": 1}, Links: []pageLink{{page.URL, "read this"}, {page.URL, ""}}})
+	updatePageRank()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/documents/{id}/links", getDocumentLinksHandler).Methods("GET")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/documents/"+documentID(page.URL)+"/links", nil))
+
+	var links documentLinks
+	json.NewDecoder(recorder.Body).Decode(&links)
+	expected := documentLinks{
+		ID:       documentID(page.URL),
+		URL:      page.URL,
+		PageRank: links.PageRank,
+		Inbound:  []documentLink{{documentID(source.URL), source.URL, "read this"}, {documentID(source.URL), source.URL, ""}},
+		Outbound: []documentLink{{"", "test.com/unindexed", "elsewhere"}, {documentID(page.URL), page.URL, "top"}},
+	}
+	if recorder.Code != http.StatusOK || !reflect.DeepEqual(links, expected) {
+		t.Error("Received", recorder.Code, links, "expected", expected)
+	}
+	if links.PageRank <= 0 {
+		t.Error("Expected the page to have a PageRank but received", links.PageRank)
+	}
+
+	//Inbound links are rebuilt from a snapshot's links and follow the source page when its links change
+	restoreLinks(map[string][]pageLink{source.URL: outboundLinks[source.URL], page.URL: outboundLinks[page.URL]})
+	if restored, _ := getDocumentLinks(documentID(page.URL)); !reflect.DeepEqual(restored.Inbound, expected.Inbound) {
+		t.Error("Expected restored links to give the same inbound links but received", restored.Inbound)
+	}
+	replaceDocument(walRecord{Info: source, Counts: map[string]int{"a": 1}, Links: []pageLink{{"test.com/unindexed", "gone"}}})
+	if changed, _ := getDocumentLinks(documentID(page.URL)); len(changed.Inbound) != 0 || len(inboundLinks[page.URL]) != 0 {
+		t.Error("Expected no inbound links once the source stops linking but received", changed.Inbound)
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/documents/missing/links", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Error("Expected an unknown document to be not found but received", recorder.Code)
+	}
+}
+
+func TestGetDocument(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			http.NotFound(w, r)
+		case "/start":
+			http.Redirect(w, r, "/page", http.StatusMovedPermanently)
+		default:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, "<html lang=\"en\"><head><title>Golang</title><link rel=\"canonical\" href=\"/canonical\"></head>"+
+				"<body><p>golang channels golang</p><a href=\"/other\">other</a></body></html>")
+		}
+	}))
+	defer server.Close()
+
+	configuration.MaxDepth = 1
+	configuration.MaxParallel = 1
+	clearIndex()
+	defer clearIndex()
+	started := time.Now()
+	runCrawlJob(newCrawlJob(crawlRequest{URL: server.URL + "/start"}))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/documents/{id}", getDocumentHandler).Methods("GET")
+	router.HandleFunc("/documents/{id}/terms", getDocumentTermsHandler).Methods("GET")
+	id := documentID(server.URL + "/canonical")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/documents/"+id, nil))
+	var document documentRecord
+	json.NewDecoder(recorder.Body).Decode(&document)
+	expected := documentRecord{ID: id, URL: server.URL + "/canonical", FinalURL: server.URL + "/page", Title: "Golang", StatusCode: http.StatusOK,
+		ContentType: "text/html; charset=utf-8", Fetched: document.Fetched, NextFetch: document.NextFetch, Size: document.Size, Language: "en", Outlinks: []string{server.URL + "/other"}}
+	if recorder.Code != http.StatusOK || !reflect.DeepEqual(document, expected) {
+		t.Error("Received", recorder.Code, document, "expected", expected)
+	}
+	if document.Fetched.Before(started.Add(-time.Second)) || document.Size < 100 {
+		t.Error("Expected the fetch time and size of the page but received", document.Fetched, document.Size)
+	}
+	if document.NextFetch == nil || !document.NextFetch.Equal(document.Fetched.Add(defaultRevisit)) {
+		t.Error("Expected a new page to be fetched again after a day but received", document.NextFetch)
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/documents/"+id+"/terms", nil))
+	var terms []documentTerm
+	json.NewDecoder(recorder.Body).Decode(&terms)
+	expectedTerms := []documentTerm{{bodyField, "channels", 1, []int{2}}, {bodyField, "golang", 3, []int{0, 1, 3}}, {bodyField, "other", 1, []int{4}}, {titleField, "golang", 1, []int{0}}}
+	if recorder.Code != http.StatusOK || !reflect.DeepEqual(terms, expectedTerms) {
+		t.Error("Received", recorder.Code, terms, "expected", expectedTerms)
+	}
+
+	//The metadata is kept in snapshots
+	dir, err := ioutil.TempDir("", "kgp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := saveSnapshot(dir); err != nil {
+		t.Fatal(err)
+	}
+	clearIndex()
+	if _, ok := getDocument(id); ok {
+		t.Error("Expected clearing the index to remove the document")
+	}
+	if _, err := loadSnapshot(dir); err != nil {
+		t.Fatal(err)
+	}
+	restored, ok := getDocument(id)
+	if !ok || !restored.Fetched.Equal(document.Fetched) || !restored.NextFetch.Equal(*document.NextFetch) {
+		t.Error("Expected the document from the snapshot but received", restored, "expected", document)
+	}
+	restored.Fetched, restored.NextFetch = document.Fetched, document.NextFetch
+	if !reflect.DeepEqual(restored, document) {
+		t.Error("Expected the document from the snapshot but received", restored, "expected", document)
+	}
+
+	for _, target := range []string{"/documents/missing", "/documents/missing/terms"} {
+		recorder = httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", target, nil))
+		if recorder.Code != http.StatusNotFound {
+			t.Error("Expected", target, "to be not found but received", recorder.Code)
+		}
+	}
+}
+
+func TestRemoveDocumentsAndHosts(t *testing.T) {
+	documents := []walRecord{
+		{Info: indexCacheInfo{"A1", "http://a.com/1"}, Counts: map[string]int{"golang": 2, "shared": 1, "title:golang": 1}, Positions: map[string][]int{"golang": {0, 2}, "shared": {1}, "title:golang": {0}}},
+		{Info: indexCacheInfo{"A2", "http://a.com:8080/2"}, Counts: map[string]int{"rust": 1, "shared": 1}, Positions: map[string][]int{"rust": {0}, "shared": {1}}},
+		{Info: indexCacheInfo{"B1", "http://b.com/1"}, Counts: map[string]int{"shared": 1, "python": 1}, Positions: map[string][]int{"shared": {0}, "python": {1}},
+			Links: []pageLink{{"http://b.com/2", "python guide"}}},
+		{Info: indexCacheInfo{"B2", "http://b.com/2"}, Counts: map[string]int{"python": 3}, Positions: map[string][]int{"python": {0, 1, 2}}},
+	}
+	//What the index looks like with only the b.com pages, to compare removals against
+	clearIndex()
+	defer clearIndex()
+	for _, document := range documents[2:] {
+		replaceDocument(document)
+	}
+	expectedCache, expectedPositions, expectedLengths := indexCache, termPositions, documentLengths[bodyField]
+	clearIndex()
+	for _, document := range documents {
+		replaceDocument(document)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/documents/{id}", deleteDocumentHandler).Methods("DELETE")
+	router.HandleFunc("/hosts/{host}", deleteHostHandler).Methods("DELETE")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("DELETE", "/documents/"+documentID("http://a.com/1"), nil))
+	if recorder.Code != http.StatusNoContent {
+		t.Error("Expected the document to be removed but received", recorder.Code)
+	}
+	if results := searchIndexForWord("golang"); len(results) != 0 {
+		t.Error("Expected the removed document not to be found but received", results)
+	}
+	if dictionary.documents("shared") != 2 {
+		t.Error("Expected the other pages to keep their words but found", dictionary.documents("shared"))
+	}
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("DELETE", "/documents/"+documentID("http://a.com/1"), nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Error("Expected a removed document to be not found but received", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("DELETE", "/hosts/A.com", nil))
+	var removal hostRemoval
+	json.NewDecoder(recorder.Body).Decode(&removal)
+	if recorder.Code != http.StatusOK || removal.Removed != 1 {
+		t.Error("Expected the host's other page on any port to be removed but received", recorder.Code, removal)
+	}
+	if !reflect.DeepEqual(indexCache, expectedCache) || !reflect.DeepEqual(termPositions, expectedPositions) || !reflect.DeepEqual(documentLengths[bodyField], expectedLengths) {
+		t.Error("Expected the index to be as if only b.com had been indexed but found", indexCache, termPositions, documentLengths)
+	}
+	if _, ok := documentByID(documentID("http://a.com:8080/2")); ok {
+		t.Error("Expected the removed document's ID to be forgotten")
+	}
+
+	//Anchor text is removed along with the page it was indexed for
+	if removed, _ := removeHost("b.com"); removed != 2 || len(indexCache) != 0 || len(termPositions) != 0 || len(documentTerms) != 0 || len(anchorTerms) != 0 {
+		t.Error("Expected the index to be empty but found", removed, indexCache, termPositions, documentTerms, anchorTerms)
+	}
+}
+
+func TestRefreshDocument(t *testing.T) {
+	content := "golang"
+	status := http.StatusOK
+	var mutex sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch r.URL.Path {
+		case "/robots.txt":
+			http.NotFound(w, r)
+		default:
+			w.WriteHeader(status)
+			fmt.Fprint(w, "<html><head><title>Page</title></head><body><p>"+content+"</p><a href=\"/other\">other</a></body></html>")
+		}
+	}))
+	defer server.Close()
+
+	configuration.MaxDepth = 1
+	configuration.MaxParallel = 1
+	clearIndex()
+	defer clearIndex()
+	runCrawlJob(newCrawlJob(crawlRequest{URL: server.URL + "/"}))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/documents/{id}/refresh", refreshDocumentHandler).Methods("POST")
+	refresh := func() crawlJob {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("POST", "/documents/"+documentID(server.URL+"/")+"/refresh", nil))
+		var job crawlJob
+		json.NewDecoder(recorder.Body).Decode(&job)
+		if recorder.Code != http.StatusAccepted {
+			t.Fatal("Expected the refresh to start but received", recorder.Code)
+		}
+		return waitForJob(t, job.ID)
+	}
+
+	mutex.Lock()
+	configuration.MaxDepth = 3
+	content = "rust"
+	mutex.Unlock()
+	if job := refresh(); job.PagesFetched != 1 {
+		t.Error("Expected only the page itself to be fetched but received", job)
+	}
+	if len(searchIndexForWord("golang")) != 0 || len(searchIndexForWord("rust")) != 1 {
+		t.Error("Expected the page's new words to replace its old ones")
+	}
+	if _, ok := getDocument(documentID(server.URL + "/other")); ok {
+		t.Error("Expected a refresh not to follow links")
+	}
+
+	mutex.Lock()
+	status = http.StatusGone
+	mutex.Unlock()
+	if job := refresh(); len(job.Errors) != 1 {
+		t.Error("Expected the refresh to report the page gone but received", job)
+	}
+	if _, ok := getDocument(documentID(server.URL + "/")); ok {
+		t.Error("Expected a page that is gone to be removed")
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("POST", "/documents/missing/refresh", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Error("Expected an unknown document to be not found but received", recorder.Code)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+const fingerprintBits = 64
+
+// Computes a 64 bit SimHash over the page's words, weighting each word by how often it appears.
+// Pages with nearly the same text get fingerprints that differ in only a few bits.
+// Returns 0, meaning no fingerprint, for pages without any words.
+func simHash(words []string) uint64 {
+	if len(words) == 0 {
+		return 0
+	}
+	var weights [fingerprintBits]int
+	for _, word := range words {
+		hash := fnv.New64a()
+		hash.Write([]byte(strings.ToLower(word)))
+		sum := hash.Sum64()
+		for bit := 0; bit < fingerprintBits; bit++ {
+			if sum&(1<<uint(bit)) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var fingerprint uint64
+	for bit := 0; bit < fingerprintBits; bit++ {
+		if weights[bit] > 0 {
+			fingerprint |= 1 << uint(bit)
+		}
+	}
+	if fingerprint == 0 {
+		fingerprint = 1
+	}
+	return fingerprint
+}
+
+func hammingDistance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Groups documents whose fingerprints are within maxDistance bits of each other. The fingerprint is split
+// into maxDistance+1 bands; two fingerprints within maxDistance bits must agree on at least one whole band,
+// so only documents sharing a band need comparing.
+type duplicateIndex struct {
+	maxDistance  int
+	fingerprints map[string]uint64
+	clusters     map[string]string
+	members      map[string]map[string]bool
+	bands        []map[uint64][]string
+}
+
+// Guarded by indexCashMutex along with the rest of the index
+var nearDuplicates = newDuplicateIndex(-1)
+
+// A negative maxDistance turns duplicate detection off
+func newDuplicateIndex(maxDistance int) *duplicateIndex {
+	if maxDistance >= fingerprintBits {
+		maxDistance = fingerprintBits - 1
+	}
+	index := &duplicateIndex{
+		maxDistance:  maxDistance,
+		fingerprints: make(map[string]uint64),
+		clusters:     make(map[string]string),
+		members:      make(map[string]map[string]bool),
+	}
+	if maxDistance >= 0 {
+		index.bands = make([]map[uint64][]string, maxDistance+1)
+		for i := range index.bands {
+			index.bands[i] = make(map[uint64][]string)
+		}
+	}
+	return index
+}
+
+func (index *duplicateIndex) band(fingerprint uint64, band int) uint64 {
+	width := fingerprintBits / len(index.bands)
+	start := band * width
+	end := start + width
+	if band == len(index.bands)-1 {
+		end = fingerprintBits
+	}
+	return (fingerprint >> uint(start)) & (1<<uint(end-start) - 1)
+}
+
+// Records a document's fingerprint and returns the representative of the cluster it joined,
+// which is the document itself when it is not a near duplicate of anything already indexed
+func (index *duplicateIndex) add(URL string, fingerprint uint64) string {
+	index.remove(URL)
+	if index.bands == nil || fingerprint == 0 {
+		return URL
+	}
+
+	representative := URL
+	for band := range index.bands {
+		for _, candidate := range index.bands[band][index.band(fingerprint, band)] {
+			if hammingDistance(fingerprint, index.fingerprints[candidate]) <= index.maxDistance {
+				representative = index.clusters[candidate]
+				break
+			}
+		}
+		if representative != URL {
+			break
+		}
+	}
+	index.join(URL, fingerprint, representative)
+	return representative
+}
+
+func (index *duplicateIndex) join(URL string, fingerprint uint64, representative string) {
+	index.fingerprints[URL] = fingerprint
+	for band := range index.bands {
+		key := index.band(fingerprint, band)
+		index.bands[band][key] = append(index.bands[band][key], URL)
+	}
+	index.clusters[URL] = representative
+	if index.members[representative] == nil {
+		index.members[representative] = make(map[string]bool)
+	}
+	index.members[representative][URL] = true
+}
+
+// Forgets a document, handing its cluster to another member if it was the representative
+func (index *duplicateIndex) remove(URL string) {
+	fingerprint, ok := index.fingerprints[URL]
+	if !ok {
+		return
+	}
+	delete(index.fingerprints, URL)
+	for band := range index.bands {
+		key := index.band(fingerprint, band)
+		URLs := index.bands[band][key]
+		for i, candidate := range URLs {
+			if candidate == URL {
+				URLs = append(URLs[:i], URLs[i+1:]...)
+				break
+			}
+		}
+		if len(URLs) == 0 {
+			delete(index.bands[band], key)
+		} else {
+			index.bands[band][key] = URLs
+		}
+	}
+
+	representative := index.clusters[URL]
+	delete(index.clusters, URL)
+	members := index.members[representative]
+	delete(members, URL)
+	if representative != URL || len(members) == 0 {
+		if len(members) == 0 {
+			delete(index.members, representative)
+		}
+		return
+	}
+
+	remaining := make([]string, 0, len(members))
+	for member := range members {
+		remaining = append(remaining, member)
+	}
+	sort.Strings(remaining)
+	delete(index.members, representative)
+	index.members[remaining[0]] = members
+	for _, member := range remaining {
+		index.clusters[member] = remaining[0]
+	}
+}
+
+// Returns the representative of the document's cluster, or the document itself if it has none
+func (index *duplicateIndex) clusterOf(URL string) string {
+	if representative, ok := index.clusters[URL]; ok {
+		return representative
+	}
+	return URL
+}
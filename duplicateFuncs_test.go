@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testArticle = "the quick brown fox jumps over the lazy dog while the farmer watches from the porch and wonders " +
+	"why foxes and dogs never seem to get along in the long summer evenings near the old red barn by the river"
+
+func TestSimHash(t *testing.T) {
+	article := strings.Fields(testArticle)
+	printable := append(strings.Fields("print this page"), article...)
+	other := strings.Fields("quarterly earnings rose sharply as the company expanded its cloud business into new markets across asia")
+
+	if simHash(nil) != 0 {
+		t.Error("Expected a page without words to have no fingerprint")
+	}
+	if simHash(article) != simHash(strings.Fields(strings.ToUpper(testArticle))) {
+		t.Error("Expected fingerprints to ignore case")
+	}
+	if distance := hammingDistance(simHash(article), simHash(printable)); distance > 3 {
+		t.Errorf("Expected near duplicate pages to be within 3 bits but they were %d apart", distance)
+	}
+	if distance := hammingDistance(simHash(article), simHash(other)); distance <= 3 {
+		t.Errorf("Expected unrelated pages to be more than 3 bits apart but they were %d apart", distance)
+	}
+}
+
+func TestDuplicateIndex(t *testing.T) {
+	index := newDuplicateIndex(3)
+	if representative := index.add("a", 0xff00); representative != "a" {
+		t.Error("Expected the first page to represent itself but received", representative)
+	}
+	if representative := index.add("b", 0xff03); representative != "a" {
+		t.Error("Expected a page 2 bits away to join a's cluster but received", representative)
+	}
+	if representative := index.add("c", 0xff0f00f0); representative != "c" {
+		t.Error("Expected a distant page to start its own cluster but received", representative)
+	}
+	if representative := index.add("d", 0xff07); representative != "a" {
+		t.Error("Expected a page 3 bits away to join a's cluster but received", representative)
+	}
+
+	index.remove("a")
+	for _, URL := range []string{"b", "d"} {
+		if index.clusterOf(URL) != "b" {
+			t.Error("Expected b to represent the cluster after a was removed but found", index.clusterOf(URL))
+		}
+	}
+	if !reflect.DeepEqual(index.members["b"], map[string]bool{"b": true, "d": true}) {
+		t.Error("Unexpected cluster members", index.members)
+	}
+
+	index.remove("b")
+	index.remove("d")
+	if len(index.members) != 1 || len(index.clusters) != 1 {
+		t.Error("Expected only c to remain but found", index.clusters)
+	}
+	for _, band := range index.bands {
+		for _, URLs := range band {
+			if len(URLs) != 1 || URLs[0] != "c" {
+				t.Error("Expected removed pages to leave the bands but found", URLs)
+			}
+		}
+	}
+
+	disabled := newDuplicateIndex(-1)
+	disabled.add("a", 0xff00)
+	if representative := disabled.add("b", 0xff00); representative != "b" {
+		t.Error("Expected duplicate detection to be disabled but received", representative)
+	}
+}
+
+func TestSearchCollapsesDuplicates(t *testing.T) {
+	nearDuplicates = newDuplicateIndex(3)
+	defer func() { nearDuplicates = newDuplicateIndex(-1) }()
+
+	article := indexCacheInfo{"Article", "test.com/article"}
+	printable := indexCacheInfo{"Printable", "test.com/article/print"}
+	mirror := indexCacheInfo{"Mirror", "mirror.com/article"}
+	other := indexCacheInfo{"Other", "test.com/other"}
+	indexCache = map[string]map[indexCacheInfo]int{"fox": {article: 2, printable: 3, mirror: 2, other: 1}}
+	nearDuplicates.add(article.URL, 0xff00)
+	nearDuplicates.add(printable.URL, 0xff01)
+	nearDuplicates.add(mirror.URL, 0xff03)
+	nearDuplicates.add(other.URL, 0xf0f0f0f0)
+
+	expected := PairList{{Title: printable, Count: 3, Similar: 2}, {Title: other, Count: 1}}
+	if result := withoutScores(t, searchIndexForWord("fox")); !reflect.DeepEqual(result, expected) {
+		t.Error("Result: ", result, "does not match expected", expected)
+	}
+}
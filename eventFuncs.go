@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	eventPageFetched    = "page_fetched"
+	eventRobotsSkipped  = "robots_skipped"
+	eventAlreadySeen    = "already_seen"
+	eventFetchError     = "fetch_error"
+	eventDepthLimit     = "depth_limit"
+	eventJobFinished    = "job_finished"
+	eventSitemapFetched = "sitemap_fetched"
+	eventNotModified    = "not_modified"
+	eventOutOfScope     = "out_of_scope"
+	eventGap            = "gap"
+)
+
+// The most events kept per job; older ones are dropped and a reader that falls behind gets a gap event
+const maxJobEvents = 1000
+
+type crawlEvent struct {
+	ID     int
+	Type   string
+	URL    string `json:",omitempty"`
+	Depth  int
+	Title  string `json:",omitempty"`
+	Words  int    `json:",omitempty"`
+	Links  int    `json:",omitempty"`
+	Pages  int    `json:",omitempty"`
+	Status string `json:",omitempty"`
+	Error  string `json:",omitempty"`
+	Reason string `json:",omitempty"`
+	//For gap events, how many events were dropped before the reader saw them
+	Missed int `json:",omitempty"`
+	Time   time.Time
+}
+
+// Records an event against the job and wakes every subscriber streaming it
+func (job *crawlJob) emit(event crawlEvent) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	event.ID = job.eventsDropped + len(job.events) + 1
+	event.Time = time.Now()
+	job.events = append(job.events, event)
+	if len(job.events) > maxJobEvents {
+		job.events = job.events[1:]
+		job.eventsDropped++
+	}
+	for notify := range job.subscribers {
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (job *crawlJob) subscribe() chan struct{} {
+	notify := make(chan struct{}, 1)
+	jobsMutex.Lock()
+	job.subscribers[notify] = true
+	jobsMutex.Unlock()
+	return notify
+}
+
+func (job *crawlJob) unsubscribe(notify chan struct{}) {
+	jobsMutex.Lock()
+	delete(job.subscribers, notify)
+	jobsMutex.Unlock()
+}
+
+// Returns the events after the given event ID and whether the job has stopped emitting. Events already
+// dropped are stood in for by one gap event, whose ID is the last dropped one.
+func (job *crawlJob) eventsSince(lastID int) ([]crawlEvent, bool) {
+	jobsMutex.RLock()
+	defer jobsMutex.RUnlock()
+	if lastID < 0 {
+		lastID = 0
+	}
+	var events []crawlEvent
+	if lastID < job.eventsDropped {
+		events = append(events, crawlEvent{ID: job.eventsDropped, Type: eventGap, Missed: job.eventsDropped - lastID, Time: time.Now()})
+		lastID = job.eventsDropped
+	}
+	if next := lastID - job.eventsDropped; next < len(job.events) {
+		events = append(events, job.events[next:]...)
+	}
+	return events, job.Status != jobRunning
+}
+
+func formatEvent(event crawlEvent) (string, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data), nil
+}
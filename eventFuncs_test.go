@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFormatEvent(t *testing.T) {
+	formatted, err := formatEvent(crawlEvent{ID: 3, Type: eventFetchError, URL: "http://test.com", Error: "boom"})
+	if err != nil {
+		t.Error(err)
+	}
+	if !strings.HasPrefix(formatted, "id: 3\nevent: fetch_error\ndata: {") || !strings.HasSuffix(formatted, "}\n\n") {
+		t.Errorf("Unexpected event format %q", formatted)
+	}
+}
+
+func TestStreamJobEvents(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprint(w, "User-Agent: * \nDisallow: /private")
+		case "/a":
+			fmt.Fprint(w, "<head><Title>Test Title a</Title></head><a href=\"/a\">Self</a><a href=\"/private\">Private</a><a href=\"/b\">B</a>")
+		default:
+			fmt.Fprint(w, "<head><Title>Test Title b</Title></head><a href=\"/c\">C</a>")
+		}
+	}))
+	defer site.Close()
+
+	configuration.MaxDepth = 2
+	configuration.MaxParallel = 1
+	indexCache = map[string]map[indexCacheInfo]int{}
+
+	job := newCrawlJob(crawlRequest{URL: site.URL + "/a"})
+	router := mux.NewRouter()
+	router.HandleFunc("/jobs/{id}/events", streamJobEventsHandler)
+	api := httptest.NewServer(router)
+	defer api.Close()
+
+	resp, err := http.Get(api.URL + "/jobs/" + job.ID + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Error("Unexpected Content-Type", resp.Header.Get("Content-Type"))
+	}
+	go runCrawlJob(job)
+
+	seen := map[string]int{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "event: ") {
+			seen[strings.TrimPrefix(scanner.Text(), "event: ")]++
+		}
+	}
+
+	expected := map[string]int{eventPageFetched: 2, eventAlreadySeen: 1, eventRobotsSkipped: 1, eventDepthLimit: 1, eventJobFinished: 1}
+	for eventType, count := range expected {
+		if seen[eventType] != count {
+			t.Errorf("Expected %d %s events but received %d", count, eventType, seen[eventType])
+		}
+	}
+}
+
+func TestEventsCapped(t *testing.T) {
+	job := &crawlJob{Status: jobRunning, subscribers: make(map[chan struct{}]bool)}
+	for i := 0; i < maxJobEvents+10; i++ {
+		job.emit(crawlEvent{Type: eventPageFetched})
+	}
+	if len(job.events) != maxJobEvents {
+		t.Error("Expected the events to be capped at", maxJobEvents, "but received", len(job.events))
+	}
+
+	fixtures := []struct {
+		lastID int
+		gap    int
+		count  int
+	}{
+		{0, 10, maxJobEvents + 1},
+		{4, 6, maxJobEvents + 1},
+		{10, 0, maxJobEvents},
+		{maxJobEvents + 5, 0, 5},
+		{maxJobEvents + 10, 0, 0},
+	}
+	for _, fixture := range fixtures {
+		events, _ := job.eventsSince(fixture.lastID)
+		if len(events) != fixture.count {
+			t.Error("Expected", fixture.count, "events after", fixture.lastID, "but received", len(events))
+			continue
+		}
+		if fixture.gap > 0 && (events[0].Type != eventGap || events[0].Missed != fixture.gap || events[0].ID != 10 || events[1].ID != 11) {
+			t.Error("Expected a gap of", fixture.gap, "after", fixture.lastID, "but received", events[:2])
+		}
+		if fixture.gap == 0 && fixture.count > 0 && events[0].ID != fixture.lastID+1 {
+			t.Error("Expected the events to resume after", fixture.lastID, "but received", events[0])
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"strings"
+)
+
+const (
+	bodyField        = "body"
+	titleField       = "title"
+	headingField     = "heading"
+	descriptionField = "description"
+	anchorField      = "anchor"
+)
+
+// Every field a page's words are indexed under. The body holds all visible text, so words in the
+// other fields count twice: once as page text and again, boosted, for where they appear.
+var indexFields = []string{bodyField, titleField, headingField, descriptionField, anchorField}
+
+var defaultFieldBoosts = map[string]float64{
+	bodyField:        1,
+	titleField:       3,
+	headingField:     2,
+	descriptionField: 1.5,
+	anchorField:      2,
+}
+
+// Body words are indexed under the word itself, keeping the index's original shape, and other
+// fields under field:word. The tokenizer never puts a : in a token, so the two can never collide.
+func fieldTerm(field string, word string) string {
+	if field == "" || field == bodyField {
+		return word
+	}
+	return field + ":" + word
+}
+
+// The field an index key belongs to
+func termField(term string) string {
+	if i := strings.IndexByte(term, ':'); i >= 0 {
+		return term[:i]
+	}
+	return bodyField
+}
+
+// The word of an index key without its field
+func termWord(term string) string {
+	return term[strings.IndexByte(term, ':')+1:]
+}
+
+func isIndexField(field string) bool {
+	for _, indexField := range indexFields {
+		if field == indexField {
+			return true
+		}
+	}
+	return false
+}
+
+func fieldBoost(field string) float64 {
+	if boost, ok := configuration.FieldBoosts[field]; ok {
+		return boost
+	}
+	return defaultFieldBoosts[field]
+}
+
+// Adds a field's words to a document's counts and positions under field:word keys
+func addFieldWords(analyzer Analyzer, counts map[string]int, positions map[string][]int, field string, words []string) {
+	tokens := analyzeWords(analyzer, words)
+	for word, count := range countTokens(tokens) {
+		counts[fieldTerm(field, word)] = count
+	}
+	for word, list := range tokenPositions(tokens) {
+		positions[fieldTerm(field, word)] = list
+	}
+}
+
+// Returns the words of every h1 to h6 in order. Headings are separated by an empty word so a
+// phrase never matches across two of them.
+func getHeadingsFromDocument(document *goquery.Document) []string {
+	var words []string
+	document.Find("h1, h2, h3, h4, h5, h6").Each(func(i int, s *goquery.Selection) {
+		if i > 0 {
+			words = append(words, "")
+		}
+		words = append(words, strings.Fields(s.Text())...)
+	})
+	return words
+}
+
+func getDescriptionFromDocument(document *goquery.Document) string {
+	var description string
+	document.Find("meta[name]").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		name, _ := s.Attr("name")
+		if strings.EqualFold(strings.TrimSpace(name), "description") {
+			description, _ = s.Attr("content")
+			return false
+		}
+		return true
+	})
+	return strings.TrimSpace(description)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFieldTerm(t *testing.T) {
+	fixtures := []struct {
+		field string
+		word  string
+		term  string
+	}{
+		{"", "go", "go"},
+		{bodyField, "go", "go"},
+		{titleField, "go", "title:go"},
+		{anchorField, "go", "anchor:go"},
+	}
+	for _, fixture := range fixtures {
+		term := fieldTerm(fixture.field, fixture.word)
+		if term != fixture.term {
+			t.Errorf("Expected %s in %s to be indexed as %s but received %s", fixture.word, fixture.field, fixture.term, term)
+		}
+		if field := termField(term); fixture.field != "" && field != fixture.field {
+			t.Errorf("Expected %s to belong to %s but received %s", term, fixture.field, field)
+		}
+	}
+}
+
+func TestFieldsFromBody(t *testing.T) {
+	body := "<html><head><title>Go</title><META NAME=\"Description\" content=\" All about Go \"></head>" +
+		"<body><h1>Worker Pools</h1><p>text</p><h3>Channels</h3></body></html>"
+	document, _ := parseBody(body)
+	headings := getHeadingsFromDocument(document)
+	if !reflect.DeepEqual(headings, []string{"Worker", "Pools", "", "Channels"}) {
+		t.Error("Unexpected headings", headings)
+	}
+	if description := getDescriptionFromDocument(document); description != "All about Go" {
+		t.Error("Unexpected description", description)
+	}
+	document, _ = parseBody("<p>none</p>")
+	if description := getDescriptionFromDocument(document); description != "" {
+		t.Error("Expected no description but received", description)
+	}
+}
+
+func TestCrawlIndexesFields(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "<head><title>Kubernetes Guide</title><meta name=\"description\" content=\"cluster notes\"></head>"+
+			"<body><h2>Scheduling Pods</h2><p>footer mentions kubernetes once</p></body>")
+	}))
+	defer server.Close()
+
+	configuration.MaxDepth = 1
+	configuration.MaxParallel = 1
+	clearIndex()
+	defer clearIndex()
+	runCrawlJob(newCrawlJob(crawlRequest{URL: server.URL + "/"}))
+
+	for _, term := range []string{"kubernetes", "title:kubernetes", "title:guide", "heading:pods", "description:cluster"} {
+		if len(indexCache[term]) != 1 {
+			t.Error("Expected the page to be indexed under", term, "but found", indexCache[term])
+		}
+	}
+	if len(indexCache["title:footer"]) != 0 {
+		t.Error("Expected body words to stay out of the title field")
+	}
+}
+
+func TestFieldSearch(t *testing.T) {
+	clearIndex()
+	defer clearIndex()
+	indexFieldsDocument := func(info indexCacheInfo, title string, body string) {
+		words := strings.Fields(title + " " + body)
+		counts, _ := mapReduceWords(words)
+		positions := wordPositions(words)
+		addFieldWords(textAnalyzer, counts, positions, titleField, strings.Fields(title))
+		replaceDocument(walRecord{Info: info, Counts: counts, Positions: positions})
+	}
+	titled := indexCacheInfo{"Kubernetes Basics", "test.com/basics"}
+	footer := indexCacheInfo{"Cooking", "test.com/cooking"}
+	indexFieldsDocument(titled, "Kubernetes Basics", "an introduction to running containers")
+	indexFieldsDocument(footer, "Cooking", "recipes for soup kubernetes kubernetes")
+
+	query, _ := parseQuery("title:kubernetes")
+	if results := searchQuery(query); len(results) != 1 || results[0].Title != titled {
+		t.Error("Expected only the page titled kubernetes to match but received", results)
+	}
+	query, _ = parseQuery("title:\"kubernetes basics\"")
+	if results := searchQuery(query); len(results) != 1 || results[0].Title != titled {
+		t.Error("Expected the title phrase to match but received", results)
+	}
+
+	//The title boost outweighs the other page mentioning the word more often
+	query, _ = parseQuery("kubernetes")
+	results := searchQuery(query)
+	if len(results) != 2 || results[0].Title != titled || results[0].Count != 1 {
+		t.Error("Expected the titled page to rank first with its body count but received", results)
+	}
+
+	configuration.FieldBoosts = map[string]float64{titleField: 0}
+	defer func() { configuration.FieldBoosts = nil }()
+	if results := searchQuery(query); len(results) != 2 || results[0].Title != footer {
+		t.Error("Expected turning off the title boost to rank by body text but received", results)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type frontierItem struct {
+	URI      string
+	depth    int
+	host     string
+	priority int64
+	seq      int
+}
+
+// Links with a higher priority are fetched first; equal priorities keep the order they were found in
+type priorityQueue []frontierItem
+
+func (q priorityQueue) Len() int { return len(q) }
+func (q priorityQueue) Less(i, j int) bool {
+	if q[i].priority == q[j].priority {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].priority > q[j].priority
+}
+func (q priorityQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *priorityQueue) Push(x interface{}) { *q = append(*q, x.(frontierItem)) }
+func (q *priorityQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+type hostQueue struct {
+	queue     priorityQueue
+	active    int
+	delay     time.Duration
+	nextFetch time.Time
+}
+
+// The frontier holds every link waiting to be fetched, queued per host so each host gets its own
+// delay and connection cap while maxActive still bounds the crawl as a whole
+type frontier struct {
+	mutex     sync.Mutex
+	hosts     map[string]*hostQueue
+	active    int
+	maxActive int
+	perHost   int
+	delay     time.Duration
+	closed    bool
+	wake      chan struct{}
+	seq       int
+}
+
+func newFrontier(maxActive int, perHost int, delay time.Duration) *frontier {
+	if maxActive < 1 {
+		maxActive = 1
+	}
+	if perHost < 1 {
+		perHost = 1
+	}
+	return &frontier{
+		hosts:     make(map[string]*hostQueue),
+		maxActive: maxActive,
+		perHost:   perHost,
+		delay:     delay,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+func (f *frontier) signal() {
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Queues a link, returning false if it could not be queued because the frontier is closed or the link has no host
+func (f *frontier) push(URI string, depth int, priority int64) bool {
+	parsed, err := url.Parse(URI)
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closed {
+		return false
+	}
+	queue, ok := f.hosts[parsed.Host]
+	if !ok {
+		queue = &hostQueue{delay: f.delay}
+		f.hosts[parsed.Host] = queue
+	}
+	f.seq++
+	heap.Push(&queue.queue, frontierItem{URI, depth, parsed.Host, priority, f.seq})
+	f.signal()
+	return true
+}
+
+// Blocks until a link can be fetched without breaking a host's delay or either connection cap.
+// Returns false once the frontier is closed or the context is cancelled.
+func (f *frontier) next(ctx context.Context) (frontierItem, bool) {
+	for {
+		f.mutex.Lock()
+		if f.closed {
+			f.mutex.Unlock()
+			return frontierItem{}, false
+		}
+
+		now := time.Now()
+		wait := time.Duration(-1)
+		if f.active < f.maxActive {
+			for host, queue := range f.hosts {
+				if f.drained(queue, now) {
+					delete(f.hosts, host)
+					continue
+				}
+				if len(queue.queue) == 0 || queue.active >= f.perHost {
+					continue
+				}
+				if now.Before(queue.nextFetch) {
+					if until := queue.nextFetch.Sub(now); wait < 0 || until < wait {
+						wait = until
+					}
+					continue
+				}
+
+				item := heap.Pop(&queue.queue).(frontierItem)
+				queue.active++
+				queue.nextFetch = now.Add(queue.delay)
+				f.active++
+				f.mutex.Unlock()
+				return item, true
+			}
+		}
+		f.mutex.Unlock()
+
+		var timer <-chan time.Time
+		if wait >= 0 {
+			timer = time.After(wait)
+		}
+		select {
+		case <-f.wake:
+		case <-timer:
+		case <-ctx.Done():
+			return frontierItem{}, false
+		}
+	}
+}
+
+// Marks a fetch returned by next as finished, freeing its host and global slots
+func (f *frontier) release(item frontierItem) {
+	f.mutex.Lock()
+	f.active--
+	queue := f.hosts[item.host]
+	queue.active--
+	if f.drained(queue, time.Now()) {
+		delete(f.hosts, item.host)
+	}
+	f.mutex.Unlock()
+	f.signal()
+}
+
+// Whether a host has nothing queued or in flight and its delay has passed, so forgetting it loses nothing
+// and a crawl touching many hosts does not keep one entry for each. Callers must hold mutex.
+func (f *frontier) drained(queue *hostQueue, now time.Time) bool {
+	return len(queue.queue) == 0 && queue.active == 0 && !now.Before(queue.nextFetch)
+}
+
+// Raises a host's delay to its robots.txt Crawl-delay when that is longer than the configured delay
+func (f *frontier) setCrawlDelay(host string, delay time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if queue, ok := f.hosts[host]; ok && delay > queue.delay {
+		queue.nextFetch = queue.nextFetch.Add(delay - queue.delay)
+		queue.delay = delay
+	}
+}
+
+// Stops handing out links and returns how many queued links were dropped
+func (f *frontier) close() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	dropped := 0
+	for _, queue := range f.hosts {
+		dropped += len(queue.queue)
+		queue.queue = nil
+	}
+	f.closed = true
+	f.signal()
+	return dropped
+}
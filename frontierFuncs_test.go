@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFrontierHostDelay(t *testing.T) {
+	schedule := newFrontier(10, 1, 50*time.Millisecond)
+	schedule.push("http://a.test/1", 0, 0)
+	schedule.push("http://a.test/2", 0, 0)
+	schedule.push("http://b.test/1", 0, 0)
+
+	start := time.Now()
+	first, _ := schedule.next(context.Background())
+	second, _ := schedule.next(context.Background())
+	if first.host == second.host {
+		t.Error("Expected the second fetch to come from the idle host but received", first.URI, second.URI)
+	}
+	if time.Since(start) > 40*time.Millisecond {
+		t.Error("Fetches from different hosts should not wait on each other")
+	}
+
+	schedule.release(first)
+	schedule.release(second)
+	third, _ := schedule.next(context.Background())
+	if third.URI != "http://a.test/2" {
+		t.Error("Expected http://a.test/2 but received", third.URI)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("Expected the host delay to be honoured but only waited", time.Since(start))
+	}
+}
+
+func TestFrontierConnectionCaps(t *testing.T) {
+	fixtures := []struct {
+		maxActive int
+		perHost   int
+		URIs      []string
+		immediate int
+	}{
+		{10, 1, []string{"http://a.test/1", "http://a.test/2"}, 1},
+		{10, 2, []string{"http://a.test/1", "http://a.test/2", "http://a.test/3"}, 2},
+		{1, 2, []string{"http://a.test/1", "http://b.test/1"}, 1},
+		{2, 2, []string{"http://a.test/1", "http://b.test/1", "http://c.test/1"}, 2},
+	}
+
+	for _, fixture := range fixtures {
+		schedule := newFrontier(fixture.maxActive, fixture.perHost, 0)
+		for _, URI := range fixture.URIs {
+			schedule.push(URI, 0, 0)
+		}
+
+		var fetched []frontierItem
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			item, ok := schedule.next(ctx)
+			cancel()
+			if !ok {
+				break
+			}
+			fetched = append(fetched, item)
+		}
+		if len(fetched) != fixture.immediate {
+			t.Errorf("Expected %d fetches before a release but received %d", fixture.immediate, len(fetched))
+		}
+
+		schedule.release(fetched[0])
+		if _, ok := schedule.next(context.Background()); !ok {
+			t.Error("Expected a release to free a slot")
+		}
+	}
+}
+
+func TestFrontierCrawlDelay(t *testing.T) {
+	schedule := newFrontier(10, 1, 0)
+	schedule.push("http://a.test/1", 0, 0)
+	schedule.push("http://a.test/2", 0, 0)
+
+	start := time.Now()
+	first, _ := schedule.next(context.Background())
+	schedule.setCrawlDelay(first.host, 60*time.Millisecond)
+	schedule.release(first)
+	schedule.next(context.Background())
+	if time.Since(start) < 60*time.Millisecond {
+		t.Error("Expected the robots.txt Crawl-delay to be honoured but only waited", time.Since(start))
+	}
+}
+
+func TestFrontierForgetsDrainedHosts(t *testing.T) {
+	schedule := newFrontier(10, 1, 0)
+	schedule.push("http://a.test/1", 0, 0)
+	item, _ := schedule.next(context.Background())
+	schedule.release(item)
+	if len(schedule.hosts) != 0 {
+		t.Error("Expected a host to be forgotten once its last fetch finished but received", schedule.hosts)
+	}
+
+	//A host still inside its delay is kept so the delay holds, then forgotten once it has passed
+	schedule = newFrontier(10, 1, 30*time.Millisecond)
+	schedule.push("http://a.test/1", 0, 0)
+	item, _ = schedule.next(context.Background())
+	schedule.release(item)
+	schedule.push("http://a.test/2", 0, 0)
+	start := time.Now()
+	item, _ = schedule.next(context.Background())
+	if time.Since(start) < 20*time.Millisecond {
+		t.Error("Expected the host delay to be honoured but only waited", time.Since(start))
+	}
+	schedule.release(item)
+	if _, ok := schedule.hosts["a.test"]; !ok {
+		t.Error("Expected the host to be kept while its delay runs")
+	}
+	time.Sleep(40 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	schedule.next(ctx)
+	if len(schedule.hosts) != 0 {
+		t.Error("Expected the host to be forgotten once its delay passed but received", schedule.hosts)
+	}
+}
+
+func TestFrontierClose(t *testing.T) {
+	schedule := newFrontier(1, 1, 0)
+	schedule.push("http://a.test/1", 0, 0)
+	schedule.push("http://a.test/2", 0, 0)
+	if schedule.push("not a url", 0, 0) {
+		t.Error("Expected a link without a host to be rejected")
+	}
+
+	item, _ := schedule.next(context.Background())
+	if dropped := schedule.close(); dropped != 1 {
+		t.Errorf("Expected 1 dropped link but received %d", dropped)
+	}
+	schedule.release(item)
+	if _, ok := schedule.next(context.Background()); ok {
+		t.Error("Expected a closed frontier to stop handing out links")
+	}
+	if schedule.push("http://a.test/3", 0, 0) {
+		t.Error("Expected a closed frontier to reject new links")
+	}
+}
+
+func TestFrontierPriority(t *testing.T) {
+	schedule := newFrontier(10, 1, 0)
+	schedule.push("http://a.test/old", 0, 100)
+	schedule.push("http://a.test/link1", 0, 0)
+	schedule.push("http://a.test/new", 0, 200)
+	schedule.push("http://a.test/link2", 0, 0)
+
+	expected := []string{"http://a.test/new", "http://a.test/old", "http://a.test/link1", "http://a.test/link2"}
+	for _, URI := range expected {
+		item, _ := schedule.next(context.Background())
+		if item.URI != URI {
+			t.Error("Expected", URI, "but received", item.URI)
+		}
+		schedule.release(item)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// The most edits ~n can ask for
+const maxFuzzyEdits = 2
+
+// Each edit a fuzzy match needs scales its score by this much, so exact matches rank above misspellings
+const fuzzyWeight = 0.5
+
+// Misspellings of a word that are searched for, the most common first
+const maxFuzzyExpansions = 50
+
+// Searches with fewer results than this come with a spelling suggestion, and single word searches
+// also match misspellings
+const fewResults = 3
+
+// Matches words within maxEdits insertions, deletions or substitutions of a word. A state is the row of edit
+// distances from what has been read so far to each prefix of the word, so states only need to be kept
+// along the path being walked.
+type levenshteinAutomaton struct {
+	word     []rune
+	maxEdits int
+}
+
+func newLevenshteinAutomaton(word string, maxEdits int) levenshteinAutomaton {
+	return levenshteinAutomaton{[]rune(word), maxEdits}
+}
+
+func (automaton levenshteinAutomaton) start() []int {
+	state := make([]int, len(automaton.word)+1)
+	for i := range state {
+		state[i] = i
+	}
+	return state
+}
+
+func (automaton levenshteinAutomaton) step(state []int, r rune) []int {
+	next := make([]int, len(state))
+	next[0] = state[0] + 1
+	for i, wordRune := range automaton.word {
+		cost := 1
+		if wordRune == r {
+			cost = 0
+		}
+		next[i+1] = minInt(minInt(next[i]+1, state[i+1]+1), state[i]+cost)
+	}
+	return next
+}
+
+// Whether the text read so far is within reach of the word
+func (automaton levenshteinAutomaton) isMatch(state []int) bool {
+	return state[len(state)-1] <= automaton.maxEdits
+}
+
+// Whether reading more could still reach the word
+func (automaton levenshteinAutomaton) canMatch(state []int) bool {
+	for _, distance := range state {
+		if distance <= automaton.maxEdits {
+			return true
+		}
+	}
+	return false
+}
+
+// Edits allowed for a word of this length: none for very short words, where one edit makes most words
+// another, one up to five letters and two beyond
+func autoFuzziness(word string) int {
+	switch length := utf8.RuneCountInString(word); {
+	case length <= 2:
+		return 0
+	case length <= 5:
+		return 1
+	}
+	return 2
+}
+
+type fuzzyTerm struct {
+	Term      string
+	Distance  int
+	Documents int
+}
+
+// Finds the terms within maxEdits of the word by walking the dictionary and the automaton together,
+// leaving any branch the automaton cannot match from. The closest come first, then the most common.
+func (dictionary *termDictionary) fuzzy(word string, maxEdits int, limit int) []fuzzyTerm {
+	automaton := newLevenshteinAutomaton(word, maxEdits)
+	var terms []fuzzyTerm
+	//Edges split words at bytes, so a rune cut in two is held back until the rest of it arrives
+	var walk func(node *dictionaryNode, text string, state []int, pending string)
+	walk = func(node *dictionaryNode, text string, state []int, pending string) {
+		if node.weight > 0 && pending == "" && automaton.isMatch(state) {
+			terms = append(terms, fuzzyTerm{text, state[len(state)-1], node.weight})
+		}
+		for _, child := range node.children {
+			childState := state
+			rest := pending + child.edge
+			reachable := true
+			for rest != "" && utf8.FullRuneInString(rest) {
+				r, size := utf8.DecodeRuneInString(rest)
+				rest = rest[size:]
+				childState = automaton.step(childState, r)
+				if !automaton.canMatch(childState) {
+					reachable = false
+					break
+				}
+			}
+			if reachable {
+				walk(child, text+child.edge, childState, rest)
+			}
+		}
+	}
+	walk(dictionary.root, "", automaton.start(), "")
+
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].Distance != terms[j].Distance {
+			return terms[i].Distance < terms[j].Distance
+		}
+		if terms[i].Documents != terms[j].Documents {
+			return terms[i].Documents > terms[j].Documents
+		}
+		return terms[i].Term < terms[j].Term
+	})
+	if len(terms) > limit {
+		terms = terms[:limit]
+	}
+	return terms
+}
+
+// The number of documents a term appears in, 0 if it is not indexed
+func (dictionary *termDictionary) documents(term string) int {
+	node := dictionary.root
+	for term != "" {
+		i, found := node.child(term[0])
+		if !found || !strings.HasPrefix(term, node.children[i].edge) {
+			return 0
+		}
+		term = term[len(node.children[i].edge):]
+		node = node.children[i]
+	}
+	return node.weight
+}
+
+// Matches the term and its misspellings in the index, each scored down by the edits it is away.
+// In each field a document matching several keeps its best, and like an unscoped term only the body's
+// count is reported. Callers must hold indexCashMutex.
+func (node *queryNode) fuzzyMatches() queryMatches {
+	terms := dictionary.fuzzy(node.Term, node.Fuzzy, maxFuzzyExpansions)
+	//The word itself may only be in a field, which the body dictionary does not know
+	if len(terms) == 0 || terms[0].Distance > 0 {
+		terms = append([]fuzzyTerm{{Term: node.Term}}, terms...)
+	}
+	fields := indexFields
+	if node.Field != "" {
+		fields = []string{node.Field}
+	}
+
+	matches := queryMatches{}
+	for _, field := range fields {
+		//Every spelling is scored as if as common as the most common, so a rare misspelling does not
+		//outrank the word for being rare
+		frequency := 0
+		for _, term := range terms {
+			frequency = maxInt(frequency, len(indexCache[fieldTerm(field, term.Term)]))
+		}
+		best := queryMatches{}
+		for _, term := range terms {
+			weight := math.Pow(fuzzyWeight, float64(term.Distance))
+			for info, pair := range scoreTermAs(field, term.Term, frequency) {
+				pair.Score *= weight
+				if node.Field == "" && field != bodyField {
+					pair.Count = 0
+				}
+				if current, ok := best[info]; !ok || pair.Score > current.Score {
+					best[info] = pair
+				}
+			}
+		}
+		for info, pair := range best {
+			matches[info] = matches[info].add(pair)
+		}
+	}
+	return matches
+}
+
+// Rewrites a query with each word replaced by the closest word written on pages that finds more of them,
+// as Did you mean would offer. Returns "" when no word has a better spelling.
+func spellingSuggestion(query string) string {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return ""
+	}
+	analyzer := analyzerFor(queryLanguage(tokens))
+	indexCashMutex.RLock()
+	defer indexCashMutex.RUnlock()
+
+	changed := false
+	correct := func(word string) string {
+		text := word
+		if i := strings.LastIndexByte(text, '~'); i > 0 {
+			text = text[:i]
+		}
+		terms := analyzer.Analyze(text)
+		written := surfaceAnalyzer.Analyze(text)
+		if len(terms) != 1 || len(written) != 1 {
+			return word
+		}
+		//Corrections are words as written on pages, but how many documents they find is counted by their terms
+		documents := dictionary.documents(terms[0].Text)
+		for _, candidate := range wordDictionary.fuzzy(written[0].Text, autoFuzziness(written[0].Text), maxFuzzyExpansions) {
+			if candidate.Distance == 0 {
+				continue
+			}
+			if stems := analyzer.Analyze(candidate.Term); len(stems) == 1 && dictionary.documents(stems[0].Text) > documents {
+				changed = true
+				return candidate.Term
+			}
+		}
+		return word
+	}
+
+	var suggestion strings.Builder
+	for i, token := range tokens {
+		if i > 0 && spaceBetween(tokens[i-1], token) {
+			suggestion.WriteString(" ")
+		}
+		switch {
+		case token.quoted:
+			words := strings.Fields(token.text)
+			for j, word := range words {
+				words[j] = correct(word)
+			}
+			suggestion.WriteString("\"" + strings.Join(words, " ") + "\"")
+		case isQueryOperator(token.text) || strings.HasPrefix(strings.ToLower(token.text), "lang:"):
+			suggestion.WriteString(token.text)
+		default:
+			//Only the word after a field: is corrected
+			prefix := ""
+			if i := strings.IndexByte(token.text, ':'); i > 0 && isIndexField(strings.ToLower(token.text[:i])) {
+				prefix = token.text[:i+1]
+			}
+			word := token.text[len(prefix):]
+			if word == "" {
+				suggestion.WriteString(token.text)
+			} else {
+				suggestion.WriteString(prefix + correct(word))
+			}
+		}
+	}
+	if !changed {
+		return ""
+	}
+	return suggestion.String()
+}
+
+// Whether to write a space between two tokens, leaving none after - or ( or before ) as a query is usually typed
+func spaceBetween(previous queryToken, token queryToken) bool {
+	if !previous.quoted && (previous.text == "-" || previous.text == "(") {
+		return false
+	}
+	return token.quoted || token.text != ")"
+}
+
+func isQueryOperator(text string) bool {
+	switch text {
+	case "AND", "OR", "NOT", "NEAR", "-", "(", ")":
+		return true
+	}
+	return strings.HasPrefix(text, "NEAR/")
+}
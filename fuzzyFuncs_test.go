@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func editDistance(a []rune, b []rune) int {
+	row := make([]int, len(b)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := range a {
+		previous := row[0]
+		row[0] = i + 1
+		for j := range b {
+			cost := 1
+			if a[i] == b[j] {
+				cost = 0
+			}
+			current := row[j+1]
+			row[j+1] = minInt(minInt(row[j]+1, row[j+1]+1), previous+cost)
+			previous = current
+		}
+	}
+	return row[len(b)]
+}
+
+func TestLevenshteinAutomaton(t *testing.T) {
+	words := []string{"kubernetes", "kubernets", "cubernetes", "kubectl", "golang", "gopher", "naïve", "naive", ""}
+	for _, word := range words {
+		for maxEdits := 0; maxEdits <= 2; maxEdits++ {
+			automaton := newLevenshteinAutomaton(word, maxEdits)
+			for _, other := range words {
+				state := automaton.start()
+				for _, r := range other {
+					state = automaton.step(state, r)
+				}
+				distance := editDistance([]rune(word), []rune(other))
+				if automaton.isMatch(state) != (distance <= maxEdits) {
+					t.Errorf("Expected %q within %d edits of %q to be %v", other, maxEdits, word, distance <= maxEdits)
+				}
+			}
+		}
+	}
+}
+
+func TestFuzzyTerms(t *testing.T) {
+	terms := newTermDictionary()
+	for term, documents := range map[string]int{"kubernetes": 5, "kubernetic": 1, "cubernetes": 2, "kubectl": 4, "éa": 1, "èa": 3} {
+		terms.set(term, documents)
+	}
+	fixtures := []struct {
+		word     string
+		maxEdits int
+		terms    []fuzzyTerm
+	}{
+		{"kubernets", 1, []fuzzyTerm{{"kubernetes", 1, 5}}},
+		{"kubernets", 2, []fuzzyTerm{{"kubernetes", 1, 5}, {"cubernetes", 2, 2}, {"kubernetic", 2, 1}}},
+		{"kubernetes", 0, []fuzzyTerm{{"kubernetes", 0, 5}}},
+		{"golang", 2, nil},
+		//Accented letters count as one edit even though edges split them into bytes
+		{"ea", 1, []fuzzyTerm{{"èa", 1, 3}, {"éa", 1, 1}}},
+	}
+	for _, fixture := range fixtures {
+		if result := terms.fuzzy(fixture.word, fixture.maxEdits, 10); !reflect.DeepEqual(result, fixture.terms) {
+			t.Errorf("Expected %q within %d edits to find %v but received %v", fixture.word, fixture.maxEdits, fixture.terms, result)
+		}
+	}
+	if result := terms.fuzzy("kubernets", 2, 1); len(result) != 1 {
+		t.Error("Expected the limit to be kept but received", result)
+	}
+	if documents := terms.documents("kubectl"); documents != 4 {
+		t.Error("Expected kubectl to be in 4 documents but received", documents)
+	}
+	if documents := terms.documents("kube"); documents != 0 {
+		t.Error("Expected a prefix of terms not to be a term but received", documents)
+	}
+}
+
+func indexFuzzyCorpus() {
+	indexTestDocument(indexCacheInfo{"Kubernetes", "test.com/kubernetes"}, "kubernetes cluster setup")
+	indexTestDocument(indexCacheInfo{"Operators", "test.com/operators"}, "kubernetes operators")
+	indexTestDocument(indexCacheInfo{"Helm", "test.com/helm"}, "kubernetes helm charts")
+	indexTestDocument(indexCacheInfo{"Typo", "test.com/typo"}, "kubernets notes")
+}
+
+func TestFuzzySearch(t *testing.T) {
+	clearIndex()
+	defer clearIndex()
+	indexFuzzyCorpus()
+
+	query, _ := parseQuery("kubernets~")
+	results := searchQuery(query)
+	if len(results) != 4 || results[0].Title.URL != "test.com/typo" {
+		t.Error("Expected the exact match to rank above its misspellings but received", results)
+	}
+	query, _ = parseQuery("kubernets")
+	if results := searchQuery(query); len(results) != 1 {
+		t.Error("Expected a plain word to match exactly but received", results)
+	}
+	query, _ = parseQuery("kubernetes~ -helm")
+	if results := searchQuery(query); len(results) != 3 || results[2].Title.URL != "test.com/typo" {
+		t.Error("Expected the misspelling to rank last but received", results)
+	}
+
+	//Only one page has the word itself, so the word search widens to its misspellings
+	if results := searchIndexForWord("kubernets"); len(results) != 4 || results[0].Title.URL != "test.com/typo" {
+		t.Error("Expected a rare word to also find its misspellings but received", results)
+	}
+	if results := searchIndexForWord("kubernetes"); len(results) != 3 {
+		t.Error("Expected a common word to match exactly but received", results)
+	}
+}
+
+func TestSpellingSuggestion(t *testing.T) {
+	clearIndex()
+	defer clearIndex()
+	indexFuzzyCorpus()
+
+	fixtures := []struct {
+		query      string
+		suggestion string
+	}{
+		{"kubernets", "kubernetes"},
+		{"Kubernets~1 cluster", "kubernetes cluster"},
+		{"title:kubernets -(helm OR kluster)", "title:kubernetes -(helm OR cluster)"},
+		{"\"kubernets setup\" NEAR/2 oprators", "\"kubernetes setup\" NEAR/2 operators"},
+		{"kubernetes", ""},
+		{"zzzzzz", ""},
+		{"\"kubernets", ""},
+	}
+	for _, fixture := range fixtures {
+		if suggestion := spellingSuggestion(fixture.query); suggestion != fixture.suggestion {
+			t.Errorf("Expected %q to suggest %q but received %q", fixture.query, fixture.suggestion, suggestion)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	searchIndexForWordHandler(recorder, httptest.NewRequest("GET", "/search/kubernets", nil))
+	var response searchResponse
+	json.NewDecoder(recorder.Body).Decode(&response)
+	if response.Suggestion != "" {
+		t.Error("Expected a word search with enough results not to suggest anything but received", response.Suggestion)
+	}
+
+	recorder = httptest.NewRecorder()
+	searchHandler(recorder, httptest.NewRequest("GET", "/search?q=kubernets+setup", nil))
+	response = searchResponse{}
+	json.NewDecoder(recorder.Body).Decode(&response)
+	if len(response.Results) != 0 || response.Suggestion != "kubernetes setup" {
+		t.Error("Expected an empty search to suggest a spelling but received", response)
+	}
+}
+
+func TestSpellingSuggestionWrittenWords(t *testing.T) {
+	textAnalyzer = newAnalyzer("english", nil)
+	defer func() { textAnalyzer = newAnalyzer("standard", nil) }()
+	clearIndex()
+	defer clearIndex()
+	indexTestDocument(indexCacheInfo{"Concurrency", "test.com/concurrency"}, "concurrency libraries for connected computers")
+	indexTestDocument(indexCacheInfo{"Libraries", "test.com/libraries"}, "a concurrency library for the computer")
+
+	fixtures := []struct {
+		query      string
+		suggestion string
+	}{
+		{"concurency librarys", "concurrency librarys"},
+		{"computr", "computer"},
+		{"conected", "connected"},
+		{"concurrency libraries", ""},
+	}
+	for _, fixture := range fixtures {
+		if suggestion := spellingSuggestion(fixture.query); suggestion != fixture.suggestion {
+			t.Errorf("Expected %q to suggest %q but received %q", fixture.query, fixture.suggestion, suggestion)
+		}
+	}
+}
@@ -2,44 +2,45 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func indexPageHandler(w http.ResponseWriter, r *http.Request) {
-	type body struct {
-		URL string `json:"URL"`
-	}
-	var parsedBody body
-	var response []indexResponse
-	var totals indexResponse
+	var parsedBody crawlRequest
 
 	reqBody, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		respondWithError(w, http.StatusUnprocessableEntity, "Unable to read Body")
+		return
 	}
 	defer r.Body.Close()
 	json.Unmarshal(reqBody, &parsedBody)
 
-	if parsedBody.URL == "" {
-		respondWithError(w, http.StatusUnprocessableEntity, "Please include URL in Body of Request")
+	if parsedBody.URL == "" && parsedBody.Sitemap == "" {
+		respondWithError(w, http.StatusUnprocessableEntity, "Please include URL or Sitemap in Body of Request")
+	} else if _, err := newScopeRules(parsedBody); err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, err.Error())
 	} else {
-		fmt.Println("Beginning to index at:", parsedBody.URL)
-		response = crawl(Crawler{parsedBody.URL, 0}, configuration.MaxParallel)
-		for _, entity := range response {
-			totals.SitesIndexed += entity.SitesIndexed
-			totals.WordsIndexed += entity.WordsIndexed
-		}
-		respondWithJSON(w, http.StatusOK, totals)
-
+		job := newCrawlJob(parsedBody)
+		fmt.Println("Beginning to index at:", parsedBody.URL, parsedBody.Sitemap, "as job", job.ID)
+		go runCrawlJob(job)
+		respondWithJSON(w, http.StatusAccepted, job.snapshot())
 	}
 }
 
 func deleteIndexHandler(w http.ResponseWriter, r *http.Request) {
-	indexCache = make(map[string]map[indexCacheInfo]int)
+	if err := clearIndex(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to clear index: "+err.Error())
+		return
+	}
 
 	respondWithJSON(w, http.StatusNoContent, "")
 }
@@ -47,6 +48,242 @@ func deleteIndexHandler(w http.ResponseWriter, r *http.Request) {
 func searchIndexForWordHandler(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	word, _ := params["word"]
-	response := searchIndexForWord(strings.ToLower(word))
-	respondWithJSON(w, http.StatusOK, response)
+	started := time.Now()
+	page, err := searchPageFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	query, results := searchWord(strings.ToLower(word), page)
+	respondWithJSON(w, http.StatusOK, newSearchResponse(word, query, results, started))
+}
+
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	started := time.Now()
+	query, err := parseQuery(r.URL.Query().Get("q"))
+	if err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	page, err := searchPageFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newSearchResponse(r.URL.Query().Get("q"), query, searchQueryPage(query, page), started))
+}
+
+// Reads the limit, offset and cursor of the page of results asked for
+func searchPageFromRequest(r *http.Request) (searchPage, error) {
+	page := searchPage{limit: defaultSearchLimit}
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxSearchLimit {
+			return page, errors.New("limit must be between 1 and " + strconv.Itoa(maxSearchLimit))
+		}
+		page.limit = parsed
+	}
+	if value := r.URL.Query().Get("offset"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return page, errors.New("offset must be 0 or more")
+		}
+		page.offset = parsed
+	}
+	if value := r.URL.Query().Get("cursor"); value != "" {
+		after, err := decodeCursor(value)
+		if err != nil {
+			return page, err
+		}
+		page.after = after
+	}
+	return page, nil
+}
+
+func suggestHandler(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	if strings.TrimSpace(prefix) == "" {
+		respondWithError(w, http.StatusUnprocessableEntity, "Please include a prefix")
+		return
+	}
+	limit := defaultSuggestLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxSuggestLimit {
+			respondWithError(w, http.StatusUnprocessableEntity, "limit must be between 1 and "+strconv.Itoa(maxSuggestLimit))
+			return
+		}
+		limit = parsed
+	}
+	respondWithJSON(w, http.StatusOK, suggest(prefix, limit))
+}
+
+func listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, listJobs())
+}
+
+func getJobHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	job, ok := getJob(params["id"])
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, job)
+}
+
+func cancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	job, ok := cancelJob(params["id"])
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+func streamJobEventsHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	jobsMutex.RLock()
+	job, ok := jobs[params["id"]]
+	jobsMutex.RUnlock()
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+
+	//Browsers reconnect with the last event they saw so the stream resumes instead of replaying
+	lastID, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	notify := job.subscribe()
+	defer job.unsubscribe(notify)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		events, done := job.eventsSince(lastID)
+		for _, event := range events {
+			formatted, err := formatEvent(event)
+			if err != nil {
+				fmt.Println("Error formatting event: ", event)
+				continue
+			}
+			fmt.Fprint(w, formatted)
+			lastID = event.ID
+		}
+		flusher.Flush()
+		if done {
+			return
+		}
+
+		select {
+		case <-notify:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func getDocumentLinksHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	links, ok := getDocumentLinks(params["id"])
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Document not found")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, links)
+}
+
+func getDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	document, ok := getDocument(params["id"])
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Document not found")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, document)
+}
+
+func getDocumentTermsHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	terms, ok := getDocumentTerms(params["id"])
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Document not found")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, terms)
+}
+
+func deleteDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	document, ok := getDocument(params["id"])
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Document not found")
+		return
+	}
+	if _, err := removeURL(document.URL); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to remove document: "+err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+func refreshDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	document, ok := getDocument(params["id"])
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Document not found")
+		return
+	}
+	job := newCrawlJob(crawlRequest{URL: document.URL})
+	job.pages = []string{document.URL}
+	fmt.Println("Refreshing:", document.URL, "as job", job.ID)
+	go runCrawlJob(job)
+	respondWithJSON(w, http.StatusAccepted, job.snapshot())
+}
+
+type hostRemoval struct {
+	Host    string
+	Removed int
+}
+
+func deleteHostHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	removed, err := removeHost(params["host"])
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to remove host: "+err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, hostRemoval{params["host"], removed})
+}
+
+func computePageRankHandler(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, updatePageRank())
+}
+
+func listRobotsHandler(w http.ResponseWriter, r *http.Request) {
+	entries := listRobots()
+	if host := r.URL.Query().Get("host"); host != "" {
+		filtered := []robotsEntry{}
+		for _, entry := range entries {
+			if origin, err := url.Parse(entry.Origin); err == nil && origin.Host == host {
+				filtered = append(filtered, entry)
+			}
+		}
+		entries = filtered
+	}
+	respondWithJSON(w, http.StatusOK, entries)
+}
+
+func clearRobotsHandler(w http.ResponseWriter, r *http.Request) {
+	clearRobots()
+	respondWithJSON(w, http.StatusNoContent, "")
 }
@@ -2,141 +2,300 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
-	"github.com/temoto/robotstxt"
 	"golang.org/x/net/html"
-	"log"
 	"net/http"
 	"net/url"
-	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-func crawl(startLink Crawler, concurrency int) []indexResponse {
+func crawl(ctx context.Context, job *crawlJob, startLink Crawler, concurrency int) []indexResponse {
 	results := []indexResponse{}
+	scope, err := newScopeRules(job.crawlRequest)
+	if err != nil {
+		job.recordError(err)
+		return results
+	}
+	resultsMutex := sync.Mutex{}
 	type linkList struct {
-		linkList []string
-		depth    int
+		base      string
+		linkList  []string
+		depth     int
+		sitemap   []sitemapURL
+		canonical string
+		//Set for the start link, which the crawl takes whether or not it matches Include and Exclude
+		seed bool
 	}
 	worklist := make(chan linkList)
-	n := 1
-
-	var tokens = make(chan struct{}, concurrency)
-	go func() { worklist <- linkList{[]string{startLink.URI}, startLink.depth} }()
+	schedule := newFrontier(concurrency, configuration.MaxPerHost, time.Duration(configuration.HostDelay)*time.Millisecond)
 	seen := make(map[string]bool)
 
-	finish := false
-	list := linkList{}
-	ok := false
-	for ; n > 0; n-- {
-		n++
-		select {
-		case list, ok = <-worklist:
-			if ok {
-				fmt.Println("Got a new list")
+	fetch := func(item frontierItem) linkList {
+		defer schedule.release(item)
+		robots, err := getRobots(ctx, item.URI)
+		if err != nil {
+			return linkList{}
+		}
+		schedule.setCrawlDelay(item.host, robots.FindGroup(configuration.CrawlerAgent).CrawlDelay)
+		if !robotsAllow(robots, item.URI) {
+			fmt.Println("Cannot Legally Crawl Link ", item.URI)
+			job.emit(crawlEvent{Type: eventRobotsSkipped, URL: item.URI, Depth: item.depth})
+			return linkList{}
+		}
+		page, err := indexPage(ctx, job, Crawler{item.URI, item.depth})
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Println("Error fetching", item.URI, err)
+				job.recordError(err)
+				job.emit(crawlEvent{Type: eventFetchError, URL: item.URI, Depth: item.depth, Error: err.Error()})
 			}
-		default:
-			finish = true
-		}
-		depth := list.depth
-		for _, link := range list.linkList {
-			absoluteLink, err := formatURL(link, startLink.URI)
-			seenMapMutex.RLock()
-			seenLink, ok := seen[absoluteLink]
-			seenMapMutex.RUnlock()
+			return linkList{}
+		}
+		job.recordPage(page.Result)
+		resultsMutex.Lock()
+		results = append(results, page.Result)
+		resultsMutex.Unlock()
+		return linkList{base: page.URL, linkList: page.Links, depth: page.Depth, canonical: page.Canonical}
+	}
+
+	go func() {
+		for {
+			item, ok := schedule.next(ctx)
 			if !ok {
-				seenLink = false
+				return
+			}
+			go func() { worklist <- fetch(item) }()
+		}
+	}()
+
+	//Every link queued on the frontier sends exactly one list back, so pending counts the lists still owed to the worklist
+	pending := 2
+	go func() {
+		worklist <- linkList{base: startLink.URI, linkList: []string{startLink.URI}, depth: startLink.depth, seed: true}
+	}()
+	go func() {
+		worklist <- linkList{depth: startLink.depth, sitemap: loadSitemaps(ctx, job, startLink.URI)}
+	}()
+	done := ctx.Done()
+	for pending > 0 {
+		select {
+		case list := <-worklist:
+			pending--
+			//A page naming a different canonical URL stands in for it, so that URL need not be fetched
+			if list.canonical != "" {
+				seen[list.canonical] = true
+			}
+			if list.depth >= configuration.MaxDepth {
+				continue
 			}
-			if err == nil && startLink.URI != "" && canCrawl(absoluteLink) && !seenLink && startLink.depth+1 < configuration.MaxDepth {
-				seenMapMutex.Lock()
-				seen[absoluteLink] = true
-				seenMapMutex.Unlock()
-
-				go func(link string, token chan struct{}) {
-					foundLinks, depth, pageResults := indexPage(Crawler{link, depth}, token)
-					results = append(results, pageResults)
-					if foundLinks != nil {
-						worklist <- linkList{foundLinks, depth}
-					} else if finish {
-						n = 0
-					}
-				}(absoluteLink, tokens)
-			} else {
-				if !canCrawl(absoluteLink) {
-					fmt.Println("Cannot Legally Crawl Link ", absoluteLink)
-				} else if seen[absoluteLink] {
+			queue := func(link string, base string, priority int64) {
+				absoluteLink, err := formatURL(link, base)
+				if err != nil {
+					return
+				}
+				canonicalLink, err := canonicalURL(absoluteLink)
+				if err != nil {
+					return
+				}
+				if seen[canonicalLink] {
 					fmt.Println("Already seen link", absoluteLink, " Skipping")
+					job.emit(crawlEvent{Type: eventAlreadySeen, URL: absoluteLink, Depth: list.depth})
+					return
+				}
+				seen[canonicalLink] = true
+				check := scope.check
+				if list.seed {
+					check = scope.checkSeed
 				}
+				if reason := check(absoluteLink); reason != "" {
+					job.recordSkipped(reason)
+					job.emit(crawlEvent{Type: eventOutOfScope, URL: absoluteLink, Depth: list.depth, Reason: reason})
+					return
+				}
+				if schedule.push(absoluteLink, list.depth, priority) {
+					scope.queue(absoluteLink)
+					pending++
+				}
+			}
+			for _, link := range list.linkList {
+				queue(link, list.base, 0)
 			}
+			for _, entry := range list.sitemap {
+				queue(entry.Loc, entry.Loc, sitemapPriority(entry.LastMod))
+			}
+		case <-done:
+			//Links still queued will never be fetched, so stop waiting on them
+			pending -= schedule.close()
+			done = nil
 		}
 	}
+	schedule.close()
 	return results
 }
 
-func indexPage(uri Crawler, token chan struct{}) ([]string, int, indexResponse) {
-	token <- struct{}{}
+type indexedPage struct {
+	URL       string
+	Canonical string
+	Links     []string
+	Depth     int
+	Result    indexResponse
+}
+
+func indexPage(ctx context.Context, job *crawlJob, uri Crawler) (indexedPage, error) {
 	fmt.Println("Indexing: ", uri.URI, "at depth", strconv.Itoa(uri.depth))
-	resp, _ := getRequest(uri.URI)
-	<-token
+	page := indexedPage{URL: uri.URI, Depth: uri.depth + 1}
+	fetched := time.Now()
+	info, stored, known := storedDocument(uri.URI)
+	var resp *http.Response
+	var err error
+	if job.recrawl && known {
+		resp, err = getConditionalRequest(ctx, uri.URI, stored)
+	} else {
+		resp, err = getRequest(ctx, uri.URI)
+	}
+	if err != nil {
+		return page, err
+	}
 
 	buf := new(bytes.Buffer)
-	if resp.Body != nil {
-		buf.ReadFrom(resp.Body)
+	_, err = buf.ReadFrom(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return page, err
 	}
 	body := buf.String()
+	//A page that is gone is dropped from the index rather than indexed as an error page
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		if canonical, err := canonicalURL(uri.URI); err == nil {
+			if removed, _ := removeURL(canonical); removed {
+				job.recordLinksChanged()
+			}
+		}
+		return page, errors.New("Page is gone: " + resp.Status)
+	}
+	notModified := job.recrawl && known && resp.StatusCode == http.StatusNotModified
+	//Any other error keeps what was stored rather than indexing the error page, and waits longer to try again
+	if !notModified && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		if known {
+			if err := backOffDocument(info, stored, fetched); err != nil {
+				return page, err
+			}
+		}
+		return page, errors.New("Unexpected status: " + resp.Status)
+	}
+	checksum := bodyChecksum(body)
+	if job.recrawl && known && (notModified || checksum == stored.Checksum) {
+		job.emit(crawlEvent{Type: eventNotModified, URL: uri.URI, Depth: uri.depth})
+		return page, keepDocument(info, stored, resp, fetched)
+	}
+	//Relative links resolve against where any redirects ended up
+	page.URL = resp.Request.URL.String()
+	parsed, err := parseBody(body)
+	if err != nil {
+		return page, err
+	}
+
+	page.Canonical, err = canonicalURL(page.URL)
+	if err != nil {
+		return page, err
+	}
+	//A page may only speak for pages on its own site, or any page could overwrite another site's document
+	if canonical := getCanonicalFromDocument(parsed); canonical != "" {
+		if absolute, err := formatURL(canonical, page.URL); err == nil && sameSite(absolute, page.URL) {
+			if canonical, err := canonicalURL(absolute); err == nil {
+				page.Canonical = canonical
+			}
+		}
+	}
 
-	title, _ := getTitleFromBody(body)
+	title := getTitleFromDocument(parsed)
 	words, _ := getWordsFromBody(body)
 
-	urlCache, totalWords := mapReduceWords(words)
+	headings := getHeadingsFromDocument(parsed)
+	description := getDescriptionFromDocument(parsed)
+
+	language := pageLanguage(parsed, resp.Header.Get("Content-Language"), words)
+	analyzer := analyzerFor(language)
+	tokens := analyzeWords(analyzer, words)
+	text := strings.Join(words, " ")
+	urlCache := countTokens(tokens)
+	totalWords := len(urlCache)
 	fmt.Println("Total Words Cached for Title", title, ":", strconv.Itoa(totalWords))
-	updateCache(urlCache, indexCacheInfo{title, uri.URI})
+	positions := tokenPositions(tokens)
+	addFieldWords(analyzer, urlCache, positions, titleField, strings.Fields(title))
+	addFieldWords(analyzer, urlCache, positions, headingField, headings)
+	addFieldWords(analyzer, urlCache, positions, descriptionField, strings.Fields(description))
+	fingerprint := simHash(words)
+	anchors := getAnchorsFromDocument(parsed)
+	document := walRecord{Info: indexCacheInfo{title, page.Canonical}, Counts: urlCache, Positions: positions, Fingerprint: fingerprint,
+		Links: canonicalLinks(anchors, page.URL), Language: language, Text: compressText(text), Words: surfaceWords(analyzer, text),
+		Metadata: &documentMetadata{FinalURL: page.URL, StatusCode: resp.StatusCode, ContentType: resp.Header.Get("Content-Type"),
+			Fetched: fetched, Size: len(body), ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified"),
+			Checksum: checksum, Interval: revisitAfter(stored, known, checksum)}}
+	if changesLinkGraph(document.Info.URL, document.Links) {
+		job.recordLinksChanged()
+	}
+	if err := replaceDocument(document); err != nil {
+		return page, err
+	}
+	page.Result = indexResponse{1, totalWords}
 
-	links, _ := getLinksFromBody(body)
+	page.Links = getLinksFromDocument(parsed)
+	job.emit(crawlEvent{Type: eventPageFetched, URL: uri.URI, Depth: uri.depth, Title: title, Words: totalWords, Links: len(page.Links)})
+	//Refreshes and recrawls fetch only the pages asked for
+	if job.pages != nil {
+		page.Links = nil
+	}
 	//If Max Depth is reached don't continue adding links to the queue
-	if uri.depth >= configuration.MaxDepth {
-		links = nil
+	if uri.depth+1 >= configuration.MaxDepth {
+		if len(page.Links) > 0 {
+			job.emit(crawlEvent{Type: eventDepthLimit, URL: uri.URI, Depth: uri.depth, Links: len(page.Links)})
+		}
+		page.Links = nil
 	}
-	return links, uri.depth + 1, indexResponse{1, totalWords}
+	return page, nil
 
 }
 
-func getRequest(uri string) (*http.Response, error) {
-	client := &http.Client{}
-	req, _ := http.NewRequest("GET", uri, nil)
-	req.Header.Set("User-Agent", configuration.CrawlerAgent)
+// Shared by page and robots.txt fetches so both go out with the same settings and connection pool
+var crawlClient = &http.Client{Timeout: 30 * time.Second}
 
-	res, err := client.Do(req)
+func newCrawlRequest(ctx context.Context, uri string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
 	if err != nil {
 		return nil, err
 	}
-
-	return res, nil
+	req.Header.Set("User-Agent", configuration.CrawlerAgent)
+	return req, nil
 }
 
-func canCrawl(URL string) bool {
-	//Check robots.txt
-
-	parsedUrl, err := url.Parse(URL)
+func getRequest(ctx context.Context, uri string) (*http.Response, error) {
+	req, err := newCrawlRequest(ctx, uri)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	robotsURL := parsedUrl.Scheme + "://" + parsedUrl.Host + "/robots.txt"
-	resp, err := http.Get(robotsURL)
+
+	res, err := crawlClient.Do(req)
 	if err != nil {
-		return false
+		return nil, err
 	}
 
-	data, err := robotstxt.FromResponse(resp)
-	resp.Body.Close()
+	return res, nil
+}
+
+func canCrawl(URL string) bool {
+	//Check robots.txt
+	data, err := getRobots(context.Background(), URL)
 	if err != nil {
-		log.Println("Error parsing robots.txt for URL", robotsURL, err.Error())
 		return false
 	}
-	return data.TestAgent(URL, configuration.CrawlerAgent)
+	return robotsAllow(data, URL)
 }
 
 func formatURL(link string, base string) (string, error) {
@@ -155,29 +314,37 @@ func formatURL(link string, base string) (string, error) {
 	return formattedURL.String(), nil
 }
 
-func getTitleFromBody(body string) (string, error) {
-	document, err := goquery.NewDocumentFromReader(strings.NewReader(body))
-	if err != nil {
-		return "", err
-	}
-	title := document.Find("Title").Text()
-	return title, nil
+// Parses a page once for every extractor that reads its document tree
+func parseBody(body string) (*goquery.Document, error) {
+	return goquery.NewDocumentFromReader(strings.NewReader(body))
 }
 
-func getLinksFromBody(body string) ([]string, error) {
-	document, err := goquery.NewDocumentFromReader(strings.NewReader(body))
-	if err != nil {
-		return nil, err
-	}
-	var links []string
-	document.Find("a").Each(func(i int, s *goquery.Selection) {
-		href, exists := s.Attr("href")
-		if exists {
-			links = append(links, href)
+func getTitleFromDocument(document *goquery.Document) string {
+	return document.Find("Title").Text()
+}
+
+func getCanonicalFromDocument(document *goquery.Document) string {
+	var canonical string
+	document.Find("link[href]").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		rel, _ := s.Attr("rel")
+		for _, value := range strings.Fields(strings.ToLower(rel)) {
+			if value == "canonical" {
+				canonical, _ = s.Attr("href")
+				return false
+			}
 		}
+		return true
 	})
+	return strings.TrimSpace(canonical)
+}
 
-	return links, nil
+func getLinksFromDocument(document *goquery.Document) []string {
+	var links []string
+	for _, anchor := range getAnchorsFromDocument(document) {
+		links = append(links, anchor.URL)
+	}
+
+	return links
 }
 
 func getWordsFromBody(body string) ([]string, error) {
@@ -209,32 +376,101 @@ loopDom:
 }
 
 func mapReduceWords(words []string) (map[string]int, int) {
-	var data = make(map[string]int)
-
-	for _, word := range words {
-		word = strings.ToLower(word)
-		if match, _ := regexp.MatchString("^[a-z]+$", word); match {
-			count := data[word]
-			data[word] = count + 1
-		}
-
-	}
+	data := countTokens(analyzeWords(textAnalyzer, words))
 
 	return data, len(data)
 }
 
-func updateCache(data map[string]int, info indexCacheInfo) map[string]map[indexCacheInfo]int {
+func updateCache(data map[string]int, info indexCacheInfo) (map[string]map[indexCacheInfo]int, error) {
+	err := logAndApply(walRecord{Op: walAdd, Info: info, Counts: data})
+	return indexCache, err
+}
+
+func applyDocument(data map[string]int, info indexCacheInfo) {
+	documentsByURL[info.URL] = info
+	documentIDs[documentID(info.URL)] = info.URL
+	lengths := make(map[string]int)
 	for word, count := range data {
-		indexCashMutex.RLock()
 		if _, found := indexCache[word]; !found {
 			indexCache[word] = make(map[indexCacheInfo]int)
 		}
-		indexCashMutex.RUnlock()
-
-		indexCashMutex.Lock()
+		field := termField(word)
+		if _, found := lengths[field]; !found {
+			lengths[field] = documentLengths[field][info]
+		}
+		lengths[field] += count - indexCache[word][info]
 		indexCache[word][info] = count
-		indexCashMutex.Unlock()
+		addDocumentTerm(info, word)
+		if field == bodyField {
+			dictionary.set(word, len(indexCache[word]))
+		}
+	}
+	for field, length := range lengths {
+		setDocumentLength(field, info, length)
 	}
+}
 
-	return indexCache
+func applyRemoveDocument(info indexCacheInfo) {
+	if documentsByURL[info.URL] == info {
+		delete(documentsByURL, info.URL)
+		delete(documentIDs, documentID(info.URL))
+	}
+	for field := range documentLengths {
+		setDocumentLength(field, info, 0)
+	}
+	applyRemovePositions(info)
+	for _, word := range indexedTerms(info) {
+		documents, ok := indexCache[word]
+		if !ok {
+			continue
+		}
+		delete(documents, info)
+		if termField(word) == bodyField {
+			dictionary.set(word, len(documents))
+		}
+		if len(documents) == 0 {
+			delete(indexCache, word)
+		}
+	}
+	delete(documentTerms, info)
+	delete(anchorTerms, info)
+}
+
+func addDocumentTerm(info indexCacheInfo, word string) {
+	if termField(word) == anchorField {
+		return
+	}
+	if documentTerms[info] == nil {
+		documentTerms[info] = make(map[string]bool)
+	}
+	documentTerms[info][word] = true
+}
+
+// Every term indexed for the document, from the forward index. Callers must hold indexCashMutex.
+func indexedTerms(info indexCacheInfo) []string {
+	terms := make([]string, 0, len(documentTerms[info])+len(anchorTerms[info]))
+	for word := range documentTerms[info] {
+		terms = append(terms, word)
+	}
+	for word := range anchorTerms[info] {
+		terms = append(terms, word)
+	}
+	return terms
+}
+
+// Builds the forward index for an index loaded in one go
+func buildDocumentTerms(index map[string]map[indexCacheInfo]int) map[indexCacheInfo]map[string]bool {
+	built := make(map[indexCacheInfo]map[string]bool)
+	for word, documents := range index {
+		if termField(word) == anchorField {
+			continue
+		}
+		for info := range documents {
+			if built[info] == nil {
+				built[info] = make(map[string]bool)
+			}
+			built[info][word] = true
+		}
+	}
+	return built
 }
@@ -89,10 +89,11 @@ func TestLinksFromBody(t *testing.T) {
 	}
 
 	for _, fixture := range fixtures {
-		links, err := getLinksFromBody(fixture.body)
+		document, err := parseBody(fixture.body)
 		if err != nil {
 			t.Error(err)
 		}
+		links := getLinksFromDocument(document)
 
 		if len(links) != len(fixture.result) {
 			t.Error()
@@ -116,16 +117,41 @@ func TestTitleFromBody(t *testing.T) {
 	}
 
 	for _, fixture := range fixtures {
-		title, err := getTitleFromBody(fixture.body)
+		document, err := parseBody(fixture.body)
 		if err != nil {
 			t.Error(err)
 		}
+		title := getTitleFromDocument(document)
 		if title != fixture.result {
 			t.Errorf("Expected Title: %s but received %s", fixture.result, title)
 		}
 	}
 }
 
+func TestCanonicalFromBody(t *testing.T) {
+	fixtures := []struct {
+		body   string
+		result string
+	}{
+		{"<head><link rel=\"canonical\" href=\"https://test.com/a\"></head>", "https://test.com/a"},
+		{"<head><link rel=\"Canonical\" href=\" /a \"></head>", "/a"},
+		{"<head><link rel=\"stylesheet\" href=\"/style.css\"><link rel=\"alternate canonical\" href=\"/b\"></head>", "/b"},
+		{"<head><link rel=\"stylesheet\" href=\"/style.css\"></head>", ""},
+		{"", ""},
+	}
+
+	for _, fixture := range fixtures {
+		document, err := parseBody(fixture.body)
+		if err != nil {
+			t.Error(err)
+		}
+		canonical := getCanonicalFromDocument(document)
+		if canonical != fixture.result {
+			t.Errorf("Expected Canonical: %s but received %s", fixture.result, canonical)
+		}
+	}
+}
+
 func TestWordsFromBody(t *testing.T) {
 	fixtures := []struct {
 		body   string
@@ -171,7 +197,9 @@ func TestCanCrawl(t *testing.T) {
 		{"http://www.test.com/test", httpmock.NewBytesResponder(500, nil), false},
 		//		{"https://test.com/test/test1.13.1.linux-arm64.tar.gz",  httpmock.NewBytesResponder(403, nil), false},
 	}
+	defer clearRobots()
 	for _, fixture := range fixtures {
+		clearRobots()
 		httpmock.RegisterResponder("GET", "http://www.test.com/robots.txt", fixture.robotsResponse)
 		robotCrawl := canCrawl(fixture.URL)
 		if robotCrawl != fixture.result {
@@ -251,7 +279,10 @@ func TestUpdateCache(t *testing.T) {
 	indexCache = map[string]map[indexCacheInfo]int{}
 
 	for _, fixture := range fixtures {
-		updatedCache := updateCache(fixture.data, indexCacheInfo{fixture.title, fixture.URL})
+		updatedCache, err := updateCache(fixture.data, indexCacheInfo{fixture.title, fixture.URL})
+		if err != nil {
+			t.Error(err)
+		}
 		if !reflect.DeepEqual(updatedCache, fixture.cache) {
 			t.Error("cache: ", updatedCache, "does not match expected", fixture.cache)
 		}
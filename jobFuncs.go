@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	jobRunning   = "running"
+	jobFinished  = "finished"
+	jobCancelled = "cancelled"
+)
+
+// The options accepted by POST /index to start a crawl
+type crawlRequest struct {
+	URL     string
+	Sitemap string `json:",omitempty"`
+	Scope   crawlScope
+}
+
+type crawlJob struct {
+	crawlRequest
+	ID           string
+	Status       string
+	PagesFetched int
+	WordsIndexed int
+	Errors       []string
+	//Links left out of the crawl, by why they were
+	Skipped   map[string]int `json:",omitempty"`
+	StartTime time.Time
+	EndTime   *time.Time
+
+	//Set for refreshes and recrawls, which fetch only these pages without following links or sitemaps
+	pages []string
+	//Set for recrawls, which ask for pages only if they have changed and re-index only those that have
+	recrawl bool
+	//Whether the job added or removed pages or changed their links, so PageRank needs computing again
+	linksChanged bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	events []crawlEvent
+	//Events dropped from the front of events once it passed maxJobEvents
+	eventsDropped int
+	subscribers   map[chan struct{}]bool
+}
+
+// The most finished jobs kept for GET /jobs; older ones are forgotten as others finish
+const maxFinishedJobs = 100
+
+var jobs = map[string]*crawlJob{}
+var jobsMutex = sync.RWMutex{}
+var runningJobs = sync.WaitGroup{}
+
+func newCrawlJob(request crawlRequest) *crawlJob {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &crawlJob{
+		crawlRequest: request,
+		ID:           newJobID(),
+		Status:       jobRunning,
+		Errors:       []string{},
+		StartTime:    time.Now(),
+		ctx:          ctx,
+		cancel:       cancel,
+		subscribers:  make(map[chan struct{}]bool),
+	}
+
+	jobsMutex.Lock()
+	jobs[job.ID] = job
+	jobsMutex.Unlock()
+	runningJobs.Add(1)
+	return job
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func runCrawlJob(job *crawlJob) {
+	defer runningJobs.Done()
+	if job.pages != nil {
+		refetchPages(job.ctx, job)
+	} else {
+		crawl(job.ctx, job, Crawler{job.URL, 0}, configuration.MaxParallel)
+	}
+	//Rank pages again before reporting the job done, unless the link graph is as it was
+	jobsMutex.RLock()
+	linksChanged := job.linksChanged
+	jobsMutex.RUnlock()
+	if linksChanged {
+		updatePageRank()
+	}
+
+	status := jobFinished
+	if job.ctx.Err() != nil {
+		status = jobCancelled
+	}
+	//Emit before changing status so streams never see a stopped job without its final event
+	snapshot := job.snapshot()
+	job.emit(crawlEvent{Type: eventJobFinished, URL: job.URL, Pages: snapshot.PagesFetched, Words: snapshot.WordsIndexed, Status: status})
+
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	now := time.Now()
+	job.EndTime = &now
+	job.Status = status
+	job.cancel()
+	evictFinishedJobs()
+}
+
+// Forgets the oldest finished jobs beyond maxFinishedJobs, so recrawls and other jobs do not pile up for
+// as long as the server runs. Callers must hold jobsMutex.
+func evictFinishedJobs() {
+	var finished []*crawlJob
+	for _, job := range jobs {
+		if job.Status != jobRunning {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].EndTime.Before(*finished[j].EndTime) })
+	for _, job := range finished[:len(finished)-maxFinishedJobs] {
+		delete(jobs, job.ID)
+	}
+}
+
+func (job *crawlJob) recordPage(result indexResponse) {
+	jobsMutex.Lock()
+	job.PagesFetched += result.SitesIndexed
+	job.WordsIndexed += result.WordsIndexed
+	jobsMutex.Unlock()
+}
+
+func (job *crawlJob) recordLinksChanged() {
+	jobsMutex.Lock()
+	job.linksChanged = true
+	jobsMutex.Unlock()
+}
+
+func (job *crawlJob) recordSkipped(reason string) {
+	jobsMutex.Lock()
+	if job.Skipped == nil {
+		job.Skipped = make(map[string]int)
+	}
+	job.Skipped[reason]++
+	jobsMutex.Unlock()
+}
+
+func (job *crawlJob) recordError(err error) {
+	jobsMutex.Lock()
+	job.Errors = append(job.Errors, err.Error())
+	jobsMutex.Unlock()
+}
+
+// Returns a copy of the job that is safe to serialize while the crawl is still running
+func (job *crawlJob) snapshot() crawlJob {
+	jobsMutex.RLock()
+	defer jobsMutex.RUnlock()
+	copied := *job
+	copied.Errors = append([]string{}, job.Errors...)
+	if job.Skipped != nil {
+		copied.Skipped = make(map[string]int, len(job.Skipped))
+		for reason, count := range job.Skipped {
+			copied.Skipped[reason] = count
+		}
+	}
+	return copied
+}
+
+func getJob(id string) (crawlJob, bool) {
+	jobsMutex.RLock()
+	job, ok := jobs[id]
+	jobsMutex.RUnlock()
+	if !ok {
+		return crawlJob{}, false
+	}
+	return job.snapshot(), true
+}
+
+func listJobs() []crawlJob {
+	jobsMutex.RLock()
+	list := make([]*crawlJob, 0, len(jobs))
+	for _, job := range jobs {
+		list = append(list, job)
+	}
+	jobsMutex.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].StartTime.Before(list[j].StartTime) })
+	snapshots := make([]crawlJob, len(list))
+	for i, job := range list {
+		snapshots[i] = job.snapshot()
+	}
+	return snapshots
+}
+
+func cancelJob(id string) (crawlJob, bool) {
+	jobsMutex.RLock()
+	job, ok := jobs[id]
+	jobsMutex.RUnlock()
+	if !ok {
+		return crawlJob{}, false
+	}
+	job.cancel()
+	return job.snapshot(), true
+}
+
+// Cancels every running job and waits for their crawls to stop
+func cancelAllJobs() {
+	jobsMutex.RLock()
+	for _, job := range jobs {
+		job.cancel()
+	}
+	jobsMutex.RUnlock()
+	runningJobs.Wait()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func waitForJob(t *testing.T, id string) crawlJob {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, ok := getJob(id)
+		if !ok {
+			t.Fatal("Job", id, "not found")
+		}
+		if job.Status != jobRunning {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Job", id, "did not finish in time")
+	return crawlJob{}
+}
+
+func TestCrawlJob(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			http.NotFound(w, r)
+		case "/a":
+			fmt.Fprint(w, "<head><Title>Test Title a</Title></head><a href=\"/b\">Test Link</a>")
+		case "/b":
+			fmt.Fprint(w, "<head><Title>Test Title b</Title></head><a href=\"/a\">Test Link</a><a href=\"/missing\">Other</a>")
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	configuration.MaxDepth = 3
+	configuration.MaxParallel = 2
+	indexCache = map[string]map[indexCacheInfo]int{}
+
+	job := newCrawlJob(crawlRequest{URL: server.URL + "/a"})
+	runCrawlJob(job)
+
+	result := waitForJob(t, job.ID)
+	if result.Status != jobFinished {
+		t.Errorf("Expected Status: %s but received %s", jobFinished, result.Status)
+	}
+	if result.PagesFetched != 2 || len(result.Errors) != 1 {
+		t.Errorf("Expected PagesFetched: %d with the failing page as an error but received %d %v", 2, result.PagesFetched, result.Errors)
+	}
+	if result.EndTime == nil {
+		t.Error("Expected EndTime to be set")
+	}
+	if _, ok := indexCache["b"]; !ok {
+		t.Error("Expected word from linked page to be indexed")
+	}
+}
+
+func TestCancelCrawlJob(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	configuration.MaxDepth = 3
+	configuration.MaxParallel = 2
+
+	job := newCrawlJob(crawlRequest{URL: server.URL + "/slow"})
+	go runCrawlJob(job)
+
+	time.Sleep(50 * time.Millisecond)
+	if _, ok := cancelJob(job.ID); !ok {
+		t.Fatal("Expected job to be found")
+	}
+
+	result := waitForJob(t, job.ID)
+	if result.Status != jobCancelled {
+		t.Errorf("Expected Status: %s but received %s", jobCancelled, result.Status)
+	}
+	if len(result.Errors) != 0 {
+		t.Error("Cancelled fetches should not be recorded as errors", result.Errors)
+	}
+
+	if _, ok := cancelJob("missing"); ok {
+		t.Error("Expected unknown job to not be found")
+	}
+}
+
+func TestFinishedJobsEvicted(t *testing.T) {
+	var started []string
+	for i := 0; i <= maxFinishedJobs; i++ {
+		job := newCrawlJob(crawlRequest{})
+		job.pages = []string{}
+		runCrawlJob(job)
+		started = append(started, job.ID)
+	}
+
+	finished := 0
+	for _, job := range listJobs() {
+		if job.Status != jobRunning {
+			finished++
+		}
+	}
+	if finished != maxFinishedJobs {
+		t.Errorf("Expected %d finished jobs to be kept but received %d", maxFinishedJobs, finished)
+	}
+	if _, ok := getJob(started[0]); ok {
+		t.Error("Expected the oldest finished job to be forgotten")
+	}
+	if _, ok := getJob(started[len(started)-1]); !ok {
+		t.Error("Expected the latest job to be kept")
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// The analyzer each language code can be given in config
+var languageAnalyzerNames = map[string]string{"en": "english", "de": "german", "es": "spanish"}
+
+// Analyzers for the languages in config, keyed by language code. Set once at startup.
+var languageAnalyzers = map[string]Analyzer{}
+
+// The language of each indexed page, keyed by canonical URL. Guarded by indexCashMutex.
+var documentLanguages = map[string]string{}
+
+// Pages with fewer letters than this are too short to guess the language of
+const minDetectLetters = 20
+
+// Only the start of a long page is read to detect its language
+const maxDetectWords = 1000
+
+// A language is only detected when this share of the text's letter sequences appear in its sample, and
+// each sequence is on average this much more likely, as a log probability, than in the next best language.
+// Text in a language without a profile fits none of them this well.
+const minDetectCoverage = 0.35
+const minDetectMargin = 0.15
+
+// Text in each language the detector knows, from which it learns how often each three letter sequence appears
+var languageSamples = map[string]string{
+	"en": "The quick development of the web has changed how people find information. Search engines crawl pages, " +
+		"follow links and build an index of the words they contain. When you type a question, the engine looks up " +
+		"each word and ranks the pages that match. This is what they have been doing for years, and it works because " +
+		"most pages are written with care. There are many other things that could be said about it, but the main idea " +
+		"is simple: with enough data, the right answer is usually near the top of the list. Would you like to know more " +
+		"about how this system works and which of these ideas are still used today?",
+	"de": "Die schnelle Entwicklung des Internets hat verändert, wie Menschen Informationen finden. Suchmaschinen " +
+		"durchsuchen Seiten, folgen den Verweisen und erstellen einen Index der Wörter, die sie enthalten. Wenn man " +
+		"eine Frage eingibt, schlägt die Maschine jedes Wort nach und ordnet die passenden Seiten. Das machen sie schon " +
+		"seit vielen Jahren, und es funktioniert, weil die meisten Seiten sorgfältig geschrieben sind. Es gibt noch " +
+		"viele andere Dinge, die man darüber sagen könnte, aber die Grundidee ist einfach: mit genug Daten steht die " +
+		"richtige Antwort meistens ganz oben in der Liste. Möchten Sie mehr darüber wissen, wie dieses System " +
+		"funktioniert und welche dieser Ideen heute noch verwendet werden?",
+	"es": "El rápido desarrollo de la red ha cambiado la forma en que las personas encuentran información. Los " +
+		"buscadores recorren las páginas, siguen los enlaces y construyen un índice de las palabras que contienen. " +
+		"Cuando escribes una pregunta, el buscador consulta cada palabra y ordena las páginas que coinciden. Esto es " +
+		"lo que hacen desde hace muchos años, y funciona porque la mayoría de las páginas están escritas con cuidado. " +
+		"Hay muchas otras cosas que se podrían decir sobre esto, pero la idea principal es sencilla: con suficientes " +
+		"datos, la respuesta correcta suele estar cerca del principio de la lista. ¿Quieres saber más sobre cómo " +
+		"funciona este sistema y cuáles de estas ideas todavía se usan hoy?",
+}
+
+type languageProfile struct {
+	trigrams map[string]int
+	total    int
+}
+
+var languageProfiles = buildLanguageProfiles()
+
+func buildLanguageProfiles() map[string]languageProfile {
+	profiles := make(map[string]languageProfile, len(languageSamples))
+	for language, sample := range languageSamples {
+		profile := languageProfile{trigrams: make(map[string]int)}
+		for _, trigram := range letterTrigrams(strings.Fields(sample)) {
+			profile.trigrams[trigram]++
+			profile.total++
+		}
+		profiles[language] = profile
+	}
+	return profiles
+}
+
+// Splits lowercased words into overlapping three letter sequences, with a space marking where each word
+// starts and ends. Anything other than letters separates words.
+func letterTrigrams(words []string) []string {
+	var trigrams []string
+	for _, word := range words {
+		for _, part := range strings.FieldsFunc(strings.ToLower(word), func(r rune) bool { return !unicode.IsLetter(r) }) {
+			runes := []rune(" " + part + " ")
+			for i := 0; i+3 <= len(runes); i++ {
+				trigrams = append(trigrams, string(runes[i:i+3]))
+			}
+		}
+	}
+	return trigrams
+}
+
+// Guesses the language of the words from how likely their letter sequences are in each known language,
+// returning "" when there is too little text to tell or the text fits none of them clearly
+func detectLanguage(words []string) string {
+	if len(words) > maxDetectWords {
+		words = words[:maxDetectWords]
+	}
+	letters := 0
+	for _, word := range words {
+		for _, r := range word {
+			if unicode.IsLetter(r) {
+				letters++
+			}
+		}
+	}
+	if letters < minDetectLetters {
+		return ""
+	}
+
+	trigrams := letterTrigrams(words)
+	best := ""
+	bestScore, runnerUp := math.Inf(-1), math.Inf(-1)
+	bestKnown := 0
+	for language, profile := range languageProfiles {
+		//Add one to every count so a sequence missing from the sample lowers the score rather than ruling the language out
+		vocabulary := float64(profile.total + len(profile.trigrams))
+		score := 0.0
+		known := 0
+		for _, trigram := range trigrams {
+			score += math.Log(float64(profile.trigrams[trigram]+1) / vocabulary)
+			if profile.trigrams[trigram] > 0 {
+				known++
+			}
+		}
+		if score > bestScore || score == bestScore && language < best {
+			best, bestScore, runnerUp, bestKnown = language, score, bestScore, known
+		} else if score > runnerUp {
+			runnerUp = score
+		}
+	}
+	count := float64(len(trigrams))
+	if float64(bestKnown)/count < minDetectCoverage || (bestScore-runnerUp)/count < minDetectMargin {
+		return ""
+	}
+	return best
+}
+
+// Reduces a language tag such as en-GB or de_AT to its primary language, or "" if it is not one
+func normalizeLanguage(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	if len(tag) < 2 || len(tag) > 3 {
+		return ""
+	}
+	for _, r := range tag {
+		if r < 'a' || r > 'z' {
+			return ""
+		}
+	}
+	return tag
+}
+
+func getLanguageFromDocument(document *goquery.Document) string {
+	lang, _ := document.Find("html").Attr("lang")
+	return normalizeLanguage(lang)
+}
+
+// Decides a page's language from its html lang attribute, then its Content-Language header, which may list
+// several languages of which the first is used, and finally from its words
+func pageLanguage(document *goquery.Document, contentLanguage string, words []string) string {
+	if language := getLanguageFromDocument(document); language != "" {
+		return language
+	}
+	if language := normalizeLanguage(strings.Split(contentLanguage, ",")[0]); language != "" {
+		return language
+	}
+	return detectLanguage(words)
+}
+
+// The analyzer for text in the language, falling back to the default for languages without one
+func analyzerFor(language string) Analyzer {
+	if analyzer, ok := languageAnalyzers[language]; ok {
+		return analyzer
+	}
+	return textAnalyzer
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeLanguage(t *testing.T) {
+	fixtures := []struct {
+		tag      string
+		language string
+	}{
+		{"en", "en"},
+		{" de-AT ", "de"},
+		{"es_MX", "es"},
+		{"", ""},
+		{"english", ""},
+		{"x1", ""},
+	}
+	for _, fixture := range fixtures {
+		if language := normalizeLanguage(fixture.tag); language != fixture.language {
+			t.Errorf("Expected %q to normalize to %q but received %q", fixture.tag, fixture.language, language)
+		}
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	fixtures := []struct {
+		text     string
+		language string
+	}{
+		{"Our team builds tools that help developers ship software faster and with fewer bugs.", "en"},
+		{"Unser Team entwickelt Werkzeuge, mit denen Entwickler schneller und mit weniger Fehlern arbeiten können.", "de"},
+		{"Nuestro equipo crea herramientas que ayudan a los desarrolladores a publicar programas más rápido.", "es"},
+		{"Hello world", ""},
+		{"La recherche sur le web permet de trouver rapidement les pages qui contiennent les mots que vous cherchez.", ""},
+		{"La ville se trouve au bord du fleuve et elle est connue pour ses vieux ponts", ""},
+		{"Il nostro gruppo crea strumenti che aiutano gli sviluppatori a pubblicare programmi più velocemente e con meno errori.", ""},
+		{"La città si trova sulla riva del fiume ed è famosa per i suoi ponti antichi", ""},
+		{"我们的团队开发工具，帮助开发人员更快地发布软件，减少错误。搜索引擎抓取网页并建立索引。", ""},
+	}
+	for _, fixture := range fixtures {
+		if language := detectLanguage(strings.Fields(fixture.text)); language != fixture.language {
+			t.Errorf("Expected %q to be detected as %q but received %q", fixture.text, fixture.language, language)
+		}
+	}
+}
+
+func TestPageLanguage(t *testing.T) {
+	english := strings.Fields("the pages that match are ranked by how often the words appear")
+	fixtures := []struct {
+		body            string
+		contentLanguage string
+		language        string
+	}{
+		{"<html lang=\"es-ES\"><body></body></html>", "de", "es"},
+		{"<html><body></body></html>", "de-DE, en", "de"},
+		{"<html><body></body></html>", "", "en"},
+	}
+	for _, fixture := range fixtures {
+		document, _ := parseBody(fixture.body)
+		if language := pageLanguage(document, fixture.contentLanguage, english); language != fixture.language {
+			t.Errorf("Expected %q with Content-Language %q to be %q but received %q", fixture.body, fixture.contentLanguage, fixture.language, language)
+		}
+	}
+}
+
+func TestCrawlIndexesLanguage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			http.NotFound(w, r)
+		case "/de":
+			w.Header().Set("Content-Language", "de")
+			fmt.Fprint(w, "<html><head><title>Häuser</title></head><body><p>Die alten Häuser am Fluss</p></body></html>")
+		default:
+			fmt.Fprint(w, "<html lang=\"en\"><head><title>Houses</title></head><body><p>The houses <a href=\"/de\">Häuser</a></p></body></html>")
+		}
+	}))
+	defer server.Close()
+
+	configuration.MaxDepth = 2
+	configuration.MaxParallel = 1
+	configuration.Analyzer = "english"
+	configuration.Languages = []string{"en", "de"}
+	if err := configureAnalyzer(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		configuration.Analyzer = ""
+		configuration.Languages = nil
+		configureAnalyzer()
+	}()
+	clearIndex()
+	defer clearIndex()
+	runCrawlJob(newCrawlJob(crawlRequest{URL: server.URL + "/"}))
+
+	indexCashMutex.RLock()
+	german := documentLanguages[server.URL+"/de"]
+	english := documentLanguages[server.URL+"/"]
+	indexCashMutex.RUnlock()
+	if german != "de" || english != "en" {
+		t.Fatal("Expected the pages to be detected as de and en but received", german, english)
+	}
+
+	fixtures := []struct {
+		query string
+		URLs  []string
+	}{
+		{"haus lang:de", []string{server.URL + "/de"}},
+		{"title:hausern lang:de", []string{server.URL + "/de"}},
+		{"house lang:en", []string{server.URL + "/"}},
+		{"lang:es", nil},
+		{"-lang:de", []string{server.URL + "/"}},
+	}
+	for _, fixture := range fixtures {
+		query, err := parseQuery(fixture.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var URLs []string
+		for _, pair := range searchQuery(query) {
+			URLs = append(URLs, pair.Title.URL)
+		}
+		if strings.Join(URLs, " ") != strings.Join(fixture.URLs, " ") {
+			t.Errorf("Expected %q to find %v but received %v", fixture.query, fixture.URLs, URLs)
+		}
+	}
+}
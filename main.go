@@ -1,25 +1,65 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/tkanos/gonfig"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 )
 
 type Configuration struct {
 	MaxDepth     int
 	MaxParallel  int
+	MaxPerHost   int
+	HostDelay    int
 	Port         int
 	CrawlerAgent string
+	//Seconds robots.txt is cached, 86400 when left out, and before one that failed is fetched again, 300 when
+	//left out; negative fetches it every time
+	RobotsTTL      int
+	RobotsRetry    int
+	FollowSitemaps bool
+	TrackingParams []string
+	//Pages whose fingerprints differ by at most this many bits are collapsed in search results; negative disables
+	DuplicateDistance int
+	//BM25 term frequency saturation and length normalisation; 1.2 and 0.75 when left out
+	BM25K1 *float64
+	BM25B  *float64
+	//Weight of a match in each of body, title, heading, description and anchor; fields left out keep their defaults
+	FieldBoosts map[string]float64
+	//How much a page's PageRank adds to its score; 0.5 when left out
+	PageRankWeight *float64
+	//Analyzer for pages and queries: standard, or english to also drop stop words and stem; changing it needs a re-index
+	Analyzer string
+	//Replaces the stop word list of the english, german or spanish Analyzer when set
+	StopWords []string
+	//Languages that get their own analyzer, of en, de and es; pages in other languages use Analyzer
+	Languages []string
+	//Snippets shown with each search result, 2 when left out and none when negative, of about SnippetWords words (30)
+	Snippets     int
+	SnippetWords int
+	//Put around matching words in snippets; <em> and </em> when both are left out
+	HighlightPre  string
+	HighlightPost string
+	//Seconds between looks for pages due to be fetched again; 0 turns recrawling off
+	RecrawlInterval int
+	//Bounds in seconds on how long a page waits to be fetched again, 3600 and 2592000 when left out
+	MinRevisit       int
+	MaxRevisit       int
+	DataDir          string
+	SnapshotInterval int
 }
 
 var configuration Configuration
 
-var seenMapMutex = sync.RWMutex{}
 var indexCashMutex = sync.RWMutex{}
 
 type Crawler struct {
@@ -34,6 +74,13 @@ type indexCacheInfo struct {
 
 var indexCache = map[string]map[indexCacheInfo]int{}
 
+// The document currently indexed for each URL, so a refetch replaces it rather than adding a second copy
+var documentsByURL = map[string]indexCacheInfo{}
+
+// The terms indexed for each document, so its postings can be removed without scanning the whole index.
+// Anchor terms are kept apart in anchorTerms as they change with other pages.
+var documentTerms = map[indexCacheInfo]map[string]bool{}
+
 var sitesIndexed int
 var wordsIndexed int
 
@@ -48,12 +95,80 @@ type indexResponse struct {
 
 func main() {
 	extractConfig("config.json")
+	if err := configureAnalyzer(); err != nil {
+		log.Fatal(err)
+	}
+	nearDuplicates = newDuplicateIndex(configuration.DuplicateDistance)
+	if configuration.DataDir != "" {
+		if err := openIndex(configuration.DataDir); err != nil {
+			log.Fatal("Refusing to start with an unreadable index: ", err)
+		}
+	}
 
 	router := mux.NewRouter().StrictSlash(true)
 	router.HandleFunc("/index", indexPageHandler).Methods("POST")
 	router.HandleFunc("/index", deleteIndexHandler).Methods("DELETE")
+	router.HandleFunc("/jobs", listJobsHandler).Methods("GET")
+	router.HandleFunc("/jobs/{id}", getJobHandler).Methods("GET")
+	router.HandleFunc("/jobs/{id}", cancelJobHandler).Methods("DELETE")
+	router.HandleFunc("/jobs/{id}/events", streamJobEventsHandler).Methods("GET")
+	router.HandleFunc("/search", searchHandler).Methods("GET")
 	router.HandleFunc("/search/{word}", searchIndexForWordHandler).Methods("GET")
-	log.Fatal(http.ListenAndServe(":8080", router))
+	router.HandleFunc("/suggest", suggestHandler).Methods("GET")
+	router.HandleFunc("/admin/robots", listRobotsHandler).Methods("GET")
+	router.HandleFunc("/admin/robots", clearRobotsHandler).Methods("DELETE")
+	router.HandleFunc("/admin/pagerank", computePageRankHandler).Methods("POST")
+	router.HandleFunc("/documents/{id}", getDocumentHandler).Methods("GET")
+	router.HandleFunc("/documents/{id}", deleteDocumentHandler).Methods("DELETE")
+	router.HandleFunc("/documents/{id}/refresh", refreshDocumentHandler).Methods("POST")
+	router.HandleFunc("/documents/{id}/terms", getDocumentTermsHandler).Methods("GET")
+	router.HandleFunc("/documents/{id}/links", getDocumentLinksHandler).Methods("GET")
+	router.HandleFunc("/hosts/{host}", deleteHostHandler).Methods("DELETE")
+
+	server := &http.Server{Addr: ":8080", Handler: router}
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	var stopSnapshots func()
+	if configuration.DataDir != "" && configuration.SnapshotInterval > 0 {
+		stopSnapshots = startSnapshotter(configuration.DataDir, time.Duration(configuration.SnapshotInterval)*time.Second)
+	}
+
+	var stopRecrawls func()
+	if configuration.RecrawlInterval > 0 {
+		stopRecrawls = startRecrawler(time.Duration(configuration.RecrawlInterval) * time.Second)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	fmt.Println("Shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		fmt.Println("Error shutting down server: ", err)
+	}
+	//The recrawler is stopped first so it cannot start a job while the rest are being cancelled
+	if stopRecrawls != nil {
+		stopRecrawls()
+	}
+	cancelAllJobs()
+	//A periodic save still running could otherwise finish after the final one
+	if stopSnapshots != nil {
+		stopSnapshots()
+	}
+	if configuration.DataDir != "" {
+		if err := saveSnapshot(configuration.DataDir); err != nil {
+			log.Fatal("Error writing final snapshot: ", err)
+		}
+		if err := closeIndex(); err != nil {
+			fmt.Println("Error closing write-ahead log: ", err)
+		}
+	}
 }
 
 func extractConfig(filename string) {
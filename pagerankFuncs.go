@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const pageRankDamping = 0.85
+const pageRankIterations = 100
+const pageRankTolerance = 1e-9
+const defaultPageRankWeight = 0.5
+
+// PageRank of every page in the link graph, keyed by canonical URL and summing to 1.
+// Guarded by indexCashMutex along with the rest of the index.
+var pageRanks = map[string]float64{}
+
+// Only one computation runs at a time; a crawl finishing during an admin request waits its turn
+var pageRankMutex = sync.Mutex{}
+
+type pageRankSummary struct {
+	Pages      int
+	Links      int
+	Iterations int
+	Took       string
+}
+
+// Computes PageRank by power iteration over the given pages and every page that links or is linked to.
+// Pages without outbound links share their rank with every page, as if the reader jumped somewhere at random.
+func computePageRank(pages []string, links map[string][]pageLink) (map[string]float64, int) {
+	ids := make(map[string]int)
+	var URLs []string
+	node := func(URL string) int {
+		id, ok := ids[URL]
+		if !ok {
+			id = len(URLs)
+			ids[URL] = id
+			URLs = append(URLs, URL)
+		}
+		return id
+	}
+	for _, URL := range pages {
+		node(URL)
+	}
+	outbound := make(map[int][]int)
+	for source, sourceLinks := range links {
+		from := node(source)
+		targets := make(map[int]bool)
+		for _, link := range sourceLinks {
+			//Several links to the same page still count as one vote, and a page cannot vote for itself
+			if to := node(link.URL); to != from && !targets[to] {
+				targets[to] = true
+				outbound[from] = append(outbound[from], to)
+			}
+		}
+	}
+	count := len(URLs)
+	if count == 0 {
+		return map[string]float64{}, 0
+	}
+
+	rank := make([]float64, count)
+	for i := range rank {
+		rank[i] = 1 / float64(count)
+	}
+	iterations := 0
+	for iterations < pageRankIterations {
+		iterations++
+		dangling := 0.0
+		for i := range rank {
+			if len(outbound[i]) == 0 {
+				dangling += rank[i]
+			}
+		}
+		base := (1-pageRankDamping)/float64(count) + pageRankDamping*dangling/float64(count)
+		next := make([]float64, count)
+		for i := range next {
+			next[i] = base
+		}
+		for from, targets := range outbound {
+			share := pageRankDamping * rank[from] / float64(len(targets))
+			for _, to := range targets {
+				next[to] += share
+			}
+		}
+		change := 0.0
+		for i := range rank {
+			change += math.Abs(next[i] - rank[i])
+		}
+		rank = next
+		if change < pageRankTolerance {
+			break
+		}
+	}
+
+	ranks := make(map[string]float64, count)
+	for i, URL := range URLs {
+		ranks[URL] = rank[i]
+	}
+	return ranks, iterations
+}
+
+// Recomputes PageRank from the current link graph and swaps it into ranking
+func updatePageRank() pageRankSummary {
+	pageRankMutex.Lock()
+	defer pageRankMutex.Unlock()
+	start := time.Now()
+
+	indexCashMutex.RLock()
+	pages := make([]string, 0, len(documentsByURL))
+	for URL := range documentsByURL {
+		pages = append(pages, URL)
+	}
+	links := make(map[string][]pageLink, len(outboundLinks))
+	linkCount := 0
+	for source, sourceLinks := range outboundLinks {
+		links[source] = sourceLinks
+		linkCount += len(sourceLinks)
+	}
+	indexCashMutex.RUnlock()
+
+	ranks, iterations := computePageRank(pages, links)
+	indexCashMutex.Lock()
+	pageRanks = ranks
+	indexCashMutex.Unlock()
+
+	summary := pageRankSummary{len(ranks), linkCount, iterations, time.Since(start).String()}
+	fmt.Println("Computed PageRank for", summary.Pages, "pages in", summary.Iterations, "iterations")
+	return summary
+}
+
+// Whether indexing a page with these links changes the graph PageRank is computed over: a new page joins it,
+// while a page already indexed only changes it by linking to different pages
+func changesLinkGraph(URL string, links []pageLink) bool {
+	indexCashMutex.RLock()
+	defer indexCashMutex.RUnlock()
+	if _, known := documentsByURL[URL]; !known {
+		return true
+	}
+	stored := outboundLinks[URL]
+	if len(stored) != len(links) {
+		return true
+	}
+	for i, link := range links {
+		if link.URL != stored[i].URL {
+			return true
+		}
+	}
+	return false
+}
+
+func pageRankWeight() float64 {
+	if configuration.PageRankWeight != nil {
+		return *configuration.PageRankWeight
+	}
+	return defaultPageRankWeight
+}
+
+// Scales a page's rank so an average page scores log 2 and pages outside the graph nothing.
+// Callers must hold indexCashMutex.
+func pageRankBoost(URL string) float64 {
+	rank, ok := pageRanks[URL]
+	if !ok {
+		return 0
+	}
+	return pageRankWeight() * math.Log1p(rank*float64(len(pageRanks)))
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestComputePageRank(t *testing.T) {
+	links := map[string][]pageLink{
+		"a":   {{"hub", ""}, {"b", ""}},
+		"b":   {{"hub", ""}, {"hub", ""}},
+		"c":   {{"hub", ""}, {"c", ""}},
+		"hub": {{"a", ""}},
+	}
+	ranks, iterations := computePageRank(nil, links)
+	if iterations == 0 || iterations > pageRankIterations {
+		t.Error("Unexpected number of iterations", iterations)
+	}
+	total := 0.0
+	for _, rank := range ranks {
+		total += rank
+	}
+	if len(ranks) != 4 || math.Abs(total-1) > 1e-6 {
+		t.Errorf("Expected ranks for 4 pages summing to 1 but received %v summing to %f", ranks, total)
+	}
+	if ranks["hub"] <= ranks["a"] || ranks["a"] <= ranks["b"] || ranks["b"] <= ranks["c"] {
+		t.Error("Expected the most linked page to rank highest and the unlinked page lowest but received", ranks)
+	}
+
+	//Pages that only receive links pass their rank back to everyone rather than losing it
+	ranks, _ = computePageRank(nil, map[string][]pageLink{"a": {{"b", ""}}, "c": {{"b", ""}}})
+	if math.Abs(ranks["a"]+ranks["b"]+ranks["c"]-1) > 1e-6 || math.Abs(ranks["a"]-ranks["c"]) > 1e-9 {
+		t.Error("Unexpected ranks with a dangling page", ranks)
+	}
+
+	if ranks, _ := computePageRank(nil, nil); len(ranks) != 0 {
+		t.Error("Expected no ranks without pages but received", ranks)
+	}
+	//Indexed pages nobody links to still get a rank
+	if ranks, _ := computePageRank([]string{"a", "b"}, nil); math.Abs(ranks["a"]-0.5) > 1e-9 || math.Abs(ranks["b"]-0.5) > 1e-9 {
+		t.Error("Expected unlinked pages to share rank equally but received", ranks)
+	}
+}
+
+func TestPageRankBlendsIntoSearch(t *testing.T) {
+	clearIndex()
+	defer clearIndex()
+	popular := indexCacheInfo{"Popular", "test.com/popular"}
+	obscure := indexCacheInfo{"Obscure", "test.com/obscure"}
+	replaceDocument(walRecord{Info: popular, Counts: map[string]int{"golang": 1}})
+	replaceDocument(walRecord{Info: obscure, Counts: map[string]int{"golang": 1}})
+	for _, source := range []string{"test.com/1", "test.com/2", "test.com/3"} {
+		replaceDocument(walRecord{Info: indexCacheInfo{source, source}, Counts: map[string]int{"other": 1}, Links: []pageLink{{popular.URL, ""}}})
+	}
+
+	summary := updatePageRank()
+	if summary.Pages != 5 || summary.Links != 3 {
+		t.Error("Unexpected PageRank summary", summary)
+	}
+	results := searchIndexForWord("golang")
+	if len(results) != 2 || results[0].Title != popular || results[0].Score <= results[1].Score {
+		t.Error("Expected the linked page to rank first but received", results)
+	}
+
+	weight := 0.0
+	configuration.PageRankWeight = &weight
+	defer func() { configuration.PageRankWeight = nil }()
+	if results := searchIndexForWord("golang"); results[0].Score != results[1].Score {
+		t.Error("Expected PageRank to be ignored with a weight of 0 but received", results)
+	}
+}
+
+func TestPageRankOnlyAfterLinksChange(t *testing.T) {
+	link := "/a"
+	var mutex sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "<html><head><title>Page</title></head><body><p>"+r.URL.Path+"</p><a href=\""+link+"\">next</a></body></html>")
+	}))
+	defer server.Close()
+
+	configuration.MaxDepth = 1
+	configuration.MaxParallel = 1
+	clearIndex()
+	defer clearIndex()
+	runCrawlJob(newCrawlJob(crawlRequest{URL: server.URL + "/"}))
+	ranked := func() int {
+		indexCashMutex.RLock()
+		defer indexCashMutex.RUnlock()
+		return len(pageRanks)
+	}
+	if ranked() != 2 {
+		t.Fatal("Expected a crawl adding pages to rank them but received", pageRanks)
+	}
+
+	refresh := func() {
+		job := newCrawlJob(crawlRequest{})
+		job.pages = []string{server.URL + "/"}
+		runCrawlJob(job)
+	}
+	indexCashMutex.Lock()
+	pageRanks = map[string]float64{}
+	indexCashMutex.Unlock()
+	refresh()
+	if ranked() != 0 {
+		t.Error("Expected fetching a page again with the same links to leave PageRank alone")
+	}
+
+	mutex.Lock()
+	link = "/b"
+	mutex.Unlock()
+	refresh()
+	if ranked() != 2 {
+		t.Error("Expected a page linking somewhere new to rank pages again but received", pageRanks)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const snapshotFile = "index.snapshot"
+const snapshotMagic = "KGPI"
+const snapshotVersion = 1
+
+// magic, version, payload length, payload checksum
+const snapshotHeaderSize = 4 + 4 + 8 + 4
+
+var errCorruptSnapshot = errors.New("Snapshot is corrupt")
+
+type snapshotPosting struct {
+	Document  int
+	Count     int
+	Positions []int
+}
+
+type indexSnapshot struct {
+	Taken     time.Time
+	LastSeq   uint64
+	Documents []indexCacheInfo
+	Postings  map[string][]snapshotPosting
+	//Near duplicate fingerprints and the representative of each document's cluster, keyed by URL
+	Fingerprints map[string]uint64
+	Clusters     map[string]string
+	//Links each page makes, keyed by the page's URL; anchor text postings are rebuilt from them
+	Links map[string][]pageLink
+	//PageRank from the last computation, keyed by URL
+	PageRanks map[string]float64
+	//Detected language of each page, keyed by URL
+	Languages map[string]string
+	//Compressed extracted text of each page, keyed by URL
+	Texts map[string][]byte
+	//Distinct words written on each page, keyed by URL; the word dictionary is rebuilt from them
+	Words map[string][]string
+	//Fetch metadata of each page, keyed by URL
+	Metadata map[string]documentMetadata
+}
+
+// Flattens the index so every document's metadata is stored once and postings refer to it by position.
+// Callers must hold indexCashMutex.
+func buildSnapshot() indexSnapshot {
+	snapshot := indexSnapshot{Taken: time.Now(), Postings: make(map[string][]snapshotPosting, len(indexCache))}
+	documentIDs := map[indexCacheInfo]int{}
+	for word, documents := range indexCache {
+		postings := make([]snapshotPosting, 0, len(documents))
+		for info, count := range documents {
+			id, ok := documentIDs[info]
+			if !ok {
+				id = len(snapshot.Documents)
+				documentIDs[info] = id
+				snapshot.Documents = append(snapshot.Documents, info)
+			}
+			postings = append(postings, snapshotPosting{id, count, termPositions[word][info]})
+		}
+		sort.Slice(postings, func(i, j int) bool { return postings[i].Document < postings[j].Document })
+		snapshot.Postings[word] = postings
+	}
+	snapshot.Fingerprints = make(map[string]uint64, len(nearDuplicates.fingerprints))
+	snapshot.Clusters = make(map[string]string, len(nearDuplicates.clusters))
+	for URL, fingerprint := range nearDuplicates.fingerprints {
+		snapshot.Fingerprints[URL] = fingerprint
+		snapshot.Clusters[URL] = nearDuplicates.clusterOf(URL)
+	}
+	snapshot.Links = make(map[string][]pageLink, len(outboundLinks))
+	for URL, links := range outboundLinks {
+		snapshot.Links[URL] = links
+	}
+	snapshot.PageRanks = make(map[string]float64, len(pageRanks))
+	for URL, rank := range pageRanks {
+		snapshot.PageRanks[URL] = rank
+	}
+	snapshot.Languages = make(map[string]string, len(documentLanguages))
+	for URL, language := range documentLanguages {
+		snapshot.Languages[URL] = language
+	}
+	snapshot.Texts = make(map[string][]byte, len(documentTexts))
+	for URL, text := range documentTexts {
+		snapshot.Texts[URL] = text
+	}
+	snapshot.Words = make(map[string][]string, len(documentWords))
+	for URL, words := range documentWords {
+		snapshot.Words[URL] = words
+	}
+	snapshot.Metadata = make(map[string]documentMetadata, len(documentStore))
+	for URL, metadata := range documentStore {
+		snapshot.Metadata[URL] = metadata
+	}
+	return snapshot
+}
+
+func restoreSnapshot(snapshot indexSnapshot) error {
+	restored := make(map[string]map[indexCacheInfo]int, len(snapshot.Postings))
+	restoredURLs := make(map[string]indexCacheInfo, len(snapshot.Documents))
+	for _, info := range snapshot.Documents {
+		restoredURLs[info.URL] = info
+	}
+	restoredPositions := make(map[string]map[indexCacheInfo][]int)
+	restoredLengths := make(map[string]map[indexCacheInfo]int)
+	restoredTotal := make(map[string]int)
+	for word, postings := range snapshot.Postings {
+		restored[word] = make(map[indexCacheInfo]int, len(postings))
+		for _, posting := range postings {
+			if posting.Document < 0 || posting.Document >= len(snapshot.Documents) {
+				return errCorruptSnapshot
+			}
+			info := snapshot.Documents[posting.Document]
+			restored[word][info] = posting.Count
+			if len(posting.Positions) > 0 {
+				if restoredPositions[word] == nil {
+					restoredPositions[word] = make(map[indexCacheInfo][]int)
+				}
+				restoredPositions[word][info] = posting.Positions
+			}
+			field := termField(word)
+			if restoredLengths[field] == nil {
+				restoredLengths[field] = make(map[indexCacheInfo]int)
+			}
+			restoredLengths[field][info] += posting.Count
+			restoredTotal[field] += posting.Count
+		}
+	}
+
+	terms := buildTermDictionary(restored)
+	words := snapshot.Words
+	if words == nil {
+		words = make(map[string][]string)
+	}
+	surface := buildWordDictionary(words)
+	forward := buildDocumentTerms(restored)
+	duplicates := newDuplicateIndex(nearDuplicates.maxDistance)
+	if duplicates.bands != nil {
+		for URL, fingerprint := range snapshot.Fingerprints {
+			representative, ok := snapshot.Clusters[URL]
+			if !ok {
+				representative = URL
+			}
+			duplicates.join(URL, fingerprint, representative)
+		}
+	}
+
+	indexCashMutex.Lock()
+	indexCache = restored
+	documentsByURL = restoredURLs
+	documentIDs = buildDocumentIDs(restoredURLs)
+	documentTerms = forward
+	dictionary = terms
+	documentWords = words
+	wordDictionary = surface
+	documentLengths = restoredLengths
+	termPositions = restoredPositions
+	totalDocumentLength = restoredTotal
+	nearDuplicates = duplicates
+	restoreLinks(snapshot.Links)
+	pageRanks = snapshot.PageRanks
+	if pageRanks == nil {
+		pageRanks = make(map[string]float64)
+	}
+	documentLanguages = snapshot.Languages
+	if documentLanguages == nil {
+		documentLanguages = make(map[string]string)
+	}
+	documentTexts = snapshot.Texts
+	if documentTexts == nil {
+		documentTexts = make(map[string][]byte)
+	}
+	documentStore = snapshot.Metadata
+	if documentStore == nil {
+		documentStore = make(map[string]documentMetadata)
+	}
+	indexCashMutex.Unlock()
+	return nil
+}
+
+func encodeSnapshot(snapshot indexSnapshot) ([]byte, error) {
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(snapshot); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, snapshotHeaderSize)
+	copy(header, snapshotMagic)
+	binary.BigEndian.PutUint32(header[4:], snapshotVersion)
+	binary.BigEndian.PutUint64(header[8:], uint64(payload.Len()))
+	binary.BigEndian.PutUint32(header[16:], crc32.ChecksumIEEE(payload.Bytes()))
+	return append(header, payload.Bytes()...), nil
+}
+
+func decodeSnapshot(data []byte) (indexSnapshot, error) {
+	var snapshot indexSnapshot
+	if len(data) < snapshotHeaderSize || string(data[:4]) != snapshotMagic {
+		return snapshot, errCorruptSnapshot
+	}
+	if version := binary.BigEndian.Uint32(data[4:]); version != snapshotVersion {
+		return snapshot, fmt.Errorf("Unsupported snapshot version %d", version)
+	}
+	payload := data[snapshotHeaderSize:]
+	if binary.BigEndian.Uint64(data[8:]) != uint64(len(payload)) || binary.BigEndian.Uint32(data[16:]) != crc32.ChecksumIEEE(payload) {
+		return snapshot, errCorruptSnapshot
+	}
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&snapshot); err != nil {
+		return snapshot, errCorruptSnapshot
+	}
+	return snapshot, nil
+}
+
+// Held from building a snapshot until its log segments are retired, so an older snapshot can never be
+// renamed over a newer one whose segments are already gone
+var snapshotMutex = sync.Mutex{}
+
+// Writes the snapshot next to the old one and renames it into place so a crash never leaves a partial file.
+// The write-ahead log moves to a new segment at the same instant, and older segments are retired once the snapshot is safely on disk.
+func saveSnapshot(dir string) error {
+	snapshotMutex.Lock()
+	defer snapshotMutex.Unlock()
+	indexCashMutex.Lock()
+	snapshot := buildSnapshot()
+	if wal != nil {
+		snapshot.LastSeq = wal.seq
+		if err := wal.rotate(); err != nil {
+			indexCashMutex.Unlock()
+			return err
+		}
+	}
+	indexCashMutex.Unlock()
+
+	data, err := encodeSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, snapshotFile+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, snapshotFile)); err != nil {
+		return err
+	}
+
+	indexCashMutex.Lock()
+	defer indexCashMutex.Unlock()
+	if wal != nil {
+		return wal.retireSegments()
+	}
+	return nil
+}
+
+// Loads the snapshot from dir and returns the last logged change it contains, leaving the index empty if none has been written yet
+func loadSnapshot(dir string) (uint64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, snapshotFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	snapshot, err := decodeSnapshot(data)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", filepath.Join(dir, snapshotFile), err)
+	}
+	fmt.Println("Loaded snapshot from", snapshot.Taken, "with", len(snapshot.Documents), "documents and", len(snapshot.Postings), "words")
+	return snapshot.LastSeq, restoreSnapshot(snapshot)
+}
+
+// Saves a snapshot every interval. Returns a function that stops it and waits for any save under way to finish.
+func startSnapshotter(dir string, interval time.Duration) func() {
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := saveSnapshot(dir); err != nil {
+					fmt.Println("Error writing snapshot: ", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
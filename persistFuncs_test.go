@@ -0,0 +1,140 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kgp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	expected := map[string]map[indexCacheInfo]int{"a": {indexCacheInfo{"Test Title 1", "test.com/1"}: 2, indexCacheInfo{"Test Title 2", "test.com/2"}: 1},
+		"b": {indexCacheInfo{"Test Title 1", "test.com/1"}: 1}}
+	indexCache = expected
+	expectedPositions := map[string]map[indexCacheInfo][]int{"a": {indexCacheInfo{"Test Title 1", "test.com/1"}: {0, 4}}}
+	termPositions = expectedPositions
+	nearDuplicates = newDuplicateIndex(3)
+	defer func() { nearDuplicates = newDuplicateIndex(-1) }()
+	nearDuplicates.add("test.com/1", 0xff00)
+	nearDuplicates.add("test.com/2", 0xff01)
+	if err := saveSnapshot(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	indexCache = map[string]map[indexCacheInfo]int{}
+	termPositions = map[string]map[indexCacheInfo][]int{}
+	nearDuplicates = newDuplicateIndex(3)
+	if _, err := loadSnapshot(dir); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(indexCache, expected) {
+		t.Error("cache: ", indexCache, "does not match expected", expected)
+	}
+	if !reflect.DeepEqual(termPositions, expectedPositions) {
+		t.Error("positions: ", termPositions, "does not match expected", expectedPositions)
+	}
+	if nearDuplicates.clusterOf("test.com/2") != "test.com/1" {
+		t.Error("Expected near duplicate clusters to be restored but found", nearDuplicates.clusters)
+	}
+}
+
+func TestLoadSnapshotMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kgp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	indexCache = map[string]map[indexCacheInfo]int{}
+	if _, err := loadSnapshot(dir); err != nil {
+		t.Error("A missing snapshot should start an empty index", err)
+	}
+}
+
+func TestDecodeCorruptSnapshot(t *testing.T) {
+	indexCache = map[string]map[indexCacheInfo]int{"a": {indexCacheInfo{"Test Title 1", "test.com/1"}: 2}}
+	data, err := encodeSnapshot(buildSnapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flipped := append([]byte{}, data...)
+	flipped[len(flipped)-1] ^= 0xff
+	badVersion := append([]byte{}, data...)
+	badVersion[7] = 99
+
+	fixtures := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"bad magic", append([]byte("XXXX"), data[4:]...)},
+		{"bad version", badVersion},
+		{"flipped payload byte", flipped},
+		{"truncated", data[:len(data)-5]},
+	}
+	for _, fixture := range fixtures {
+		if _, err := decodeSnapshot(fixture.data); err == nil {
+			t.Error("Expected an error decoding a snapshot with", fixture.name)
+		}
+	}
+
+	if _, err := decodeSnapshot(data); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadCorruptSnapshotFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kgp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, snapshotFile), []byte("KGPI garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadSnapshot(dir); err == nil {
+		t.Error("Expected a corrupt snapshot file to be rejected")
+	}
+}
+
+func TestSnapshotsDoNotOverlap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kgp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clearIndex()
+	if err := openIndex(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer clearIndex()
+	defer closeIndex()
+	updateCache(map[string]int{"a": 1}, indexCacheInfo{"Page", "test.com/1"})
+
+	//A save under way holds back the next until its old segments are retired
+	snapshotMutex.Lock()
+	saved := make(chan error)
+	go func() { saved <- saveSnapshot(dir) }()
+	time.Sleep(50 * time.Millisecond)
+	if segments, _ := listWALSegments(dir); len(segments) != 1 || segments[0] != walSegmentName(1) {
+		t.Error("Expected the second save to wait before rotating the log but found", segments)
+	}
+	snapshotMutex.Unlock()
+	if err := <-saved; err != nil {
+		t.Fatal(err)
+	}
+	if segments, _ := listWALSegments(dir); len(segments) != 1 || segments[0] == walSegmentName(1) {
+		t.Error("Expected the save to rotate the log and retire the old segment but found", segments)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+// Where each word appears in each document, counted in words from the start of the page.
+// Guarded by indexCashMutex along with the rest of the index.
+var termPositions = map[string]map[indexCacheInfo][]int{}
+
+// Multiplies 1/distance² for each pair of neighbouring query words, so adjacent words add a whole point
+const proximityWeight = 1.0
+
+// A run of words matched by a term, phrase or NEAR query, from the first word's position to the last's
+type span struct {
+	start int
+	end   int
+}
+
+func applyPositions(positions map[string][]int, info indexCacheInfo) {
+	for word, list := range positions {
+		if _, found := termPositions[word]; !found {
+			termPositions[word] = make(map[indexCacheInfo][]int)
+		}
+		termPositions[word][info] = list
+		addDocumentTerm(info, word)
+	}
+}
+
+func applyRemovePositions(info indexCacheInfo) {
+	for _, word := range indexedTerms(info) {
+		documents, ok := termPositions[word]
+		if !ok {
+			continue
+		}
+		delete(documents, info)
+		if len(documents) == 0 {
+			delete(termPositions, word)
+		}
+	}
+}
+
+// Words between two spans, or 0 when they overlap
+func spanGap(a span, b span) int {
+	if b.start > a.end {
+		return b.start - a.end - 1
+	}
+	if a.start > b.end {
+		return a.start - b.end - 1
+	}
+	return 0
+}
+
+// Every place the node matches in the document. Only terms, phrases and NEAR have positions.
+func (node *queryNode) spans(info indexCacheInfo) []span {
+	switch node.Kind {
+	case termQuery:
+		positions := termPositions[fieldTerm(node.Field, node.Term)][info]
+		spans := make([]span, len(positions))
+		for i, position := range positions {
+			spans[i] = span{position, position}
+		}
+		return spans
+	case phraseQuery:
+		var spans []span
+		for _, start := range termPositions[fieldTerm(node.Field, node.Phrase[0])][info] {
+			if phraseAt(node.Field, node.Phrase, info, start) {
+				spans = append(spans, span{start, start + len(node.Phrase) - 1})
+			}
+		}
+		return spans
+	case nearQuery:
+		//Each child must fall within Distance words of a match for the children before it
+		spans := node.Children[0].spans(info)
+		for _, child := range node.Children[1:] {
+			var next []span
+			for _, candidate := range child.spans(info) {
+				for _, previous := range spans {
+					if spanGap(previous, candidate) <= node.Distance {
+						next = append(next, span{minInt(previous.start, candidate.start), maxInt(previous.end, candidate.end)})
+						break
+					}
+				}
+			}
+			spans = next
+		}
+		return spans
+	}
+	return nil
+}
+
+func phraseAt(field string, phrase []string, info indexCacheInfo, start int) bool {
+	for offset, word := range phrase[1:] {
+		//An empty word stands for one the analyzer dropped, which can be anything
+		if word == "" {
+			continue
+		}
+		if !containsPosition(termPositions[fieldTerm(field, word)][info], start+offset+1) {
+			return false
+		}
+	}
+	return true
+}
+
+// Positions are stored in ascending order
+func containsPosition(positions []int, position int) bool {
+	low, high := 0, len(positions)
+	for low < high {
+		middle := (low + high) / 2
+		if positions[middle] < position {
+			low = middle + 1
+		} else {
+			high = middle
+		}
+	}
+	return low < len(positions) && positions[low] == position
+}
+
+// Smallest distance between any position in a and any in b, both ascending, or 0 if either is empty
+func closestPositions(a []int, b []int) int {
+	closest := 0
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		distance := a[i] - b[j]
+		if distance < 0 {
+			distance = -distance
+			i++
+		} else {
+			j++
+		}
+		if distance > 0 && (closest == 0 || distance < closest) {
+			closest = distance
+		}
+	}
+	return closest
+}
+
+// Rewards documents where neighbouring query terms appear close together
+func proximityBoost(terms []string, info indexCacheInfo) float64 {
+	boost := 0.0
+	for i := 1; i < len(terms); i++ {
+		if distance := closestPositions(termPositions[terms[i-1]][info], termPositions[terms[i]][info]); distance > 0 {
+			boost += proximityWeight / float64(distance*distance)
+		}
+	}
+	return boost
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
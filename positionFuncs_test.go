@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Records the position of every term mapReduceWords would count
+func wordPositions(words []string) map[string][]int {
+	return tokenPositions(analyzeWords(textAnalyzer, words))
+}
+
+func TestWordPositions(t *testing.T) {
+	fixtures := []struct {
+		text   string
+		result map[string][]int
+	}{
+		{"Go go GO", map[string][]int{"go": {0, 1, 2}}},
+		{"worker pools, worker threads", map[string][]int{"worker": {0, 2}, "pools": {1}, "threads": {3}}},
+		{"worker -- threads", map[string][]int{"worker": {0}, "threads": {1}}},
+		{"", map[string][]int{}},
+	}
+	for _, fixture := range fixtures {
+		if result := wordPositions(strings.Fields(fixture.text)); !reflect.DeepEqual(result, fixture.result) {
+			t.Errorf("Expected %q to have positions %v but received %v", fixture.text, fixture.result, result)
+		}
+	}
+}
+
+func TestClosestPositions(t *testing.T) {
+	fixtures := []struct {
+		a      []int
+		b      []int
+		result int
+	}{
+		{[]int{0, 10}, []int{12}, 2},
+		{[]int{5}, []int{1, 4, 20}, 1},
+		{[]int{1, 30}, []int{15, 29}, 1},
+		{[]int{3}, nil, 0},
+	}
+	for _, fixture := range fixtures {
+		if result := closestPositions(fixture.a, fixture.b); result != fixture.result {
+			t.Errorf("Expected %v and %v to be %d apart but received %d", fixture.a, fixture.b, fixture.result, result)
+		}
+	}
+}
+
+func TestPhraseSpans(t *testing.T) {
+	info := indexCacheInfo{"Test", "test.com"}
+	termPositions = map[string]map[indexCacheInfo][]int{}
+	applyPositions(wordPositions(strings.Fields("a b c a b x a b c")), info)
+	defer func() { termPositions = map[string]map[indexCacheInfo][]int{} }()
+
+	phrase := &queryNode{Kind: phraseQuery, Phrase: []string{"a", "b", "c"}}
+	if spans := phrase.spans(info); !reflect.DeepEqual(spans, []span{{0, 2}, {6, 8}}) {
+		t.Error("Unexpected phrase spans", spans)
+	}
+	near := &queryNode{Kind: nearQuery, Children: []*queryNode{term("x"), phrase}, Distance: 0}
+	if spans := near.spans(info); !reflect.DeepEqual(spans, []span{{5, 8}}) {
+		t.Error("Unexpected near spans", spans)
+	}
+
+	applyRemovePositions(info)
+	if len(termPositions) != 0 {
+		t.Error("Expected removing the document to drop its positions but found", termPositions)
+	}
+}
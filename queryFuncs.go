@@ -0,0 +1,536 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type queryKind int
+
+const (
+	termQuery queryKind = iota
+	phraseQuery
+	andQuery
+	orQuery
+	notQuery
+	nearQuery
+	langQuery
+)
+
+// Words allowed between the two sides of a NEAR without a /n
+const defaultNearDistance = 10
+
+// A parsed search query. Terms and phrases are leaves, searched in every field unless Field scopes them;
+// AND, OR and NOT combine their children, and NEAR requires its term and phrase children within
+// Distance words of each other. A term with Fuzzy edits also matches words that many edits away.
+// A lang filter matches the pages in the language held in Term.
+type queryNode struct {
+	Kind     queryKind
+	Field    string
+	Term     string
+	Phrase   []string
+	Children []*queryNode
+	Distance int
+	Fuzzy    int
+}
+
+var errEmptyQuery = errors.New("Query is empty")
+
+type queryToken struct {
+	text   string
+	quoted bool
+}
+
+// Splits a query into words, quoted phrases, parentheses and leading minus signs
+func lexQuery(query string) ([]queryToken, error) {
+	var tokens []queryToken
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		switch r := runes[i]; {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == '-':
+			tokens = append(tokens, queryToken{text: string(r)})
+			i++
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, errors.New("Query has an unterminated quote")
+			}
+			tokens = append(tokens, queryToken{text: string(runes[i+1 : end]), quoted: true})
+			i = end + 1
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune("()\"", runes[end]) {
+				end++
+			}
+			tokens = append(tokens, queryToken{text: string(runes[i:end])})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type queryParser struct {
+	tokens   []queryToken
+	next     int
+	analyzer Analyzer
+}
+
+// Parses a query such as `title:golang (concurrency OR "worker pools") -java` into a tree.
+// Terms next to each other are ANDed; OR binds more loosely than AND, NEAR/n more tightly, and NOT
+// or - negates the term or group that follows. A field: prefix scopes a term, phrase or group.
+func parseQuery(query string) (*queryNode, error) {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errEmptyQuery
+	}
+	parser := &queryParser{tokens: tokens, analyzer: analyzerFor(queryLanguage(tokens))}
+	node, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.next < len(parser.tokens) {
+		return nil, errors.New("Unexpected " + parser.tokens[parser.next].text + " in query")
+	}
+	return node, nil
+}
+
+// The language a query is limited to by a lang: filter, so its words are analyzed the same way as the pages
+// it can match. Queries naming no language or several use the default analyzer.
+func queryLanguage(tokens []queryToken) string {
+	language := ""
+	for i, token := range tokens {
+		if token.quoted || !strings.HasPrefix(strings.ToLower(token.text), "lang:") {
+			continue
+		}
+		if i > 0 && !tokens[i-1].quoted && (tokens[i-1].text == "-" || tokens[i-1].text == "NOT") {
+			continue
+		}
+		tag := normalizeLanguage(token.text[len("lang:"):])
+		if language != "" && tag != language {
+			return ""
+		}
+		language = tag
+	}
+	return language
+}
+
+func (parser *queryParser) peek() (queryToken, bool) {
+	if parser.next >= len(parser.tokens) {
+		return queryToken{}, false
+	}
+	return parser.tokens[parser.next], true
+}
+
+func (parser *queryParser) isOperator(operator string) bool {
+	token, ok := parser.peek()
+	return ok && !token.quoted && token.text == operator
+}
+
+func (parser *queryParser) parseOr() (*queryNode, error) {
+	node, err := parser.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	children := []*queryNode{node}
+	for parser.isOperator("OR") {
+		parser.next++
+		node, err := parser.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, node)
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return &queryNode{Kind: orQuery, Children: children}, nil
+}
+
+func (parser *queryParser) parseAnd() (*queryNode, error) {
+	var children []*queryNode
+	for {
+		if parser.isOperator("AND") {
+			parser.next++
+		}
+		if _, ok := parser.peek(); !ok || parser.isOperator("OR") || parser.isOperator(")") {
+			break
+		}
+		node, err := parser.parseNear()
+		if err != nil {
+			return nil, err
+		}
+		if node != nil {
+			children = append(children, node)
+		}
+	}
+	switch len(children) {
+	case 0:
+		return nil, errors.New("Query is missing a term")
+	case 1:
+		return children[0], nil
+	}
+	return &queryNode{Kind: andQuery, Children: children}, nil
+}
+
+// Reads NEAR or NEAR/n, returning false if the next token is not one
+func (parser *queryParser) nearOperator() (int, bool, error) {
+	token, ok := parser.peek()
+	if !ok || token.quoted || !strings.HasPrefix(token.text, "NEAR") {
+		return 0, false, nil
+	}
+	if token.text == "NEAR" {
+		return defaultNearDistance, true, nil
+	}
+	if !strings.HasPrefix(token.text, "NEAR/") {
+		return 0, false, nil
+	}
+	distance, err := strconv.Atoi(strings.TrimPrefix(token.text, "NEAR/"))
+	if err != nil || distance < 0 {
+		return 0, false, errors.New("Query has an invalid distance in " + token.text)
+	}
+	return distance, true, nil
+}
+
+func (parser *queryParser) parseNear() (*queryNode, error) {
+	node, err := parser.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		distance, ok, err := parser.nearOperator()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return node, nil
+		}
+		parser.next++
+		if _, ok := parser.peek(); !ok {
+			return nil, errors.New("Query ends with NEAR")
+		}
+		right, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if !hasPositions(node) || !hasPositions(right) {
+			return nil, errors.New("NEAR can only join words and phrases")
+		}
+		//A chain like a NEAR/3 b NEAR/5 c keeps its own distance for each step
+		if node.Kind == nearQuery && node.Distance == distance {
+			node.Children = append(node.Children, right)
+		} else {
+			node = &queryNode{Kind: nearQuery, Children: []*queryNode{node, right}, Distance: distance}
+		}
+	}
+}
+
+func hasPositions(node *queryNode) bool {
+	return node != nil && (node.Kind == termQuery && node.Fuzzy == 0 || node.Kind == phraseQuery || node.Kind == nearQuery)
+}
+
+func (parser *queryParser) parseUnary() (*queryNode, error) {
+	if parser.isOperator("NOT") || parser.isOperator("-") {
+		parser.next++
+		if _, ok := parser.peek(); !ok {
+			return nil, errors.New("Query ends with a negation")
+		}
+		node, err := parser.parseUnary()
+		if err != nil || node == nil {
+			return node, err
+		}
+		return &queryNode{Kind: notQuery, Children: []*queryNode{node}}, nil
+	}
+	return parser.parsePrimary()
+}
+
+// Returns nil for a phrase without any words, which the caller skips
+func (parser *queryParser) parsePrimary() (*queryNode, error) {
+	token, _ := parser.peek()
+	parser.next++
+	if token.quoted {
+		return analyzedQuery(parser.analyzer, "", token.text), nil
+	}
+	if token.text == "(" {
+		node, err := parser.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !parser.isOperator(")") {
+			return nil, errors.New("Query has an unclosed parenthesis")
+		}
+		parser.next++
+		return node, nil
+	}
+	if token.text == ")" {
+		return nil, errors.New("Query has an unopened parenthesis")
+	}
+	if strings.HasPrefix(strings.ToLower(token.text), "lang:") {
+		language := normalizeLanguage(token.text[len("lang:"):])
+		if language == "" {
+			return nil, errors.New("Query has an invalid language in " + token.text)
+		}
+		return &queryNode{Kind: langQuery, Term: language}, nil
+	}
+	if i := strings.IndexByte(token.text, ':'); i > 0 && isIndexField(strings.ToLower(token.text[:i])) {
+		field := strings.ToLower(token.text[:i])
+		if i+1 < len(token.text) {
+			return parser.parseWord(field, token.text[i+1:])
+		}
+		//A bare field: applies to the phrase or group after it
+		if _, ok := parser.peek(); !ok || parser.isOperator(")") {
+			return nil, errors.New("Query has nothing to search in " + token.text)
+		}
+		node, err := parser.parsePrimary()
+		if node != nil {
+			node.scope(field)
+		}
+		return node, err
+	}
+	return parser.parseWord("", token.text)
+}
+
+// Parses a word, which may end in ~ or ~n to also match misspellings up to n edits away. A plain ~
+// allows more edits the longer the word is.
+func (parser *queryParser) parseWord(field string, text string) (*queryNode, error) {
+	i := strings.LastIndexByte(text, '~')
+	if i <= 0 {
+		return analyzedQuery(parser.analyzer, field, text), nil
+	}
+	node := analyzedQuery(parser.analyzer, field, text[:i])
+	if node == nil || node.Kind != termQuery {
+		return nil, errors.New("Query can only match misspellings of a single word in " + text)
+	}
+	if i+1 == len(text) {
+		node.Fuzzy = autoFuzziness(node.Term)
+		return node, nil
+	}
+	edits, err := strconv.Atoi(text[i+1:])
+	if err != nil || edits < 0 || edits > maxFuzzyEdits {
+		return nil, errors.New("Query has an invalid edit distance in " + text)
+	}
+	node.Fuzzy = edits
+	return node, nil
+}
+
+// Runs query text through the analyzer used for the pages it should match. Text that analyzes to several terms, like x-ray,
+// becomes a phrase, with an empty word wherever the analyzer dropped one. Returns nil if nothing is left,
+// as for a stop word.
+func analyzedQuery(analyzer Analyzer, field string, text string) *queryNode {
+	tokens := analyzer.Analyze(text)
+	switch len(tokens) {
+	case 0:
+		return nil
+	case 1:
+		return &queryNode{Kind: termQuery, Field: field, Term: tokens[0].Text}
+	}
+	first := tokens[0].Position
+	phrase := make([]string, tokens[len(tokens)-1].Position-first+1)
+	for _, token := range tokens {
+		phrase[token.Position-first] = token.Text
+	}
+	return &queryNode{Kind: phraseQuery, Field: field, Phrase: phrase}
+}
+
+// Restricts every term and phrase under the node that is not already scoped to the field
+func (node *queryNode) scope(field string) {
+	if (node.Kind == termQuery || node.Kind == phraseQuery) && node.Field == "" {
+		node.Field = field
+	}
+	for _, child := range node.Children {
+		child.scope(field)
+	}
+}
+
+// Documents matching a query with their summed BM25 score and word count. Callers must hold indexCashMutex.
+type queryMatches map[indexCacheInfo]Pair
+
+func (node *queryNode) evaluate() queryMatches {
+	switch node.Kind {
+	case termQuery:
+		if node.Fuzzy > 0 {
+			return node.fuzzyMatches()
+		}
+		if node.Field != "" {
+			return scoreTerm(node.Field, node.Term)
+		}
+		//Unscoped words may match in any field, but only the body's count is reported
+		union := queryMatches{}
+		for _, field := range indexFields {
+			for info, pair := range scoreTerm(field, node.Term) {
+				if field != bodyField {
+					pair.Count = 0
+				}
+				union[info] = union[info].add(pair)
+			}
+		}
+		return union
+	case phraseQuery, nearQuery:
+		//Find the documents with every word first, then keep those where the words line up
+		matches := (&queryNode{Kind: andQuery, Children: node.leaves()}).evaluate()
+		for info, pair := range matches {
+			spans := node.spans(info)
+			if len(spans) == 0 {
+				delete(matches, info)
+			} else if node.Kind == phraseQuery {
+				pair.Count = len(spans)
+				matches[info] = pair
+			}
+		}
+		return matches
+	case orQuery:
+		union := queryMatches{}
+		for _, child := range node.Children {
+			for info, pair := range child.evaluate() {
+				union[info] = union[info].add(pair)
+			}
+		}
+		return union
+	case notQuery:
+		return allDocuments().subtract(node.Children[0].evaluate())
+	case langQuery:
+		matches := queryMatches{}
+		for URL, language := range documentLanguages {
+			if info, ok := documentsByURL[URL]; ok && language == node.Term {
+				matches[info] = Pair{Title: info}
+			}
+		}
+		return matches
+	}
+
+	//AND intersects the positive children, smallest first, then removes anything a negated child matches
+	var positive []queryMatches
+	var negative []queryMatches
+	for _, child := range node.Children {
+		if child.Kind == notQuery {
+			negative = append(negative, child.Children[0].evaluate())
+		} else {
+			positive = append(positive, child.evaluate())
+		}
+	}
+	var intersection queryMatches
+	if len(positive) == 0 {
+		intersection = allDocuments()
+	} else {
+		sort.Slice(positive, func(i, j int) bool { return len(positive[i]) < len(positive[j]) })
+		intersection = positive[0]
+		for _, matches := range positive[1:] {
+			next := queryMatches{}
+			for info, pair := range intersection {
+				if other, ok := matches[info]; ok {
+					next[info] = pair.add(other)
+				}
+			}
+			intersection = next
+		}
+	}
+	for _, matches := range negative {
+		intersection = intersection.subtract(matches)
+	}
+	return intersection
+}
+
+// Scores a word within one field, weighted by the field's boost
+func scoreTerm(field string, word string) queryMatches {
+	return scoreTermAs(field, word, len(indexCache[fieldTerm(field, word)]))
+}
+
+// Scores a word within one field as if it appeared in frequency documents
+func scoreTermAs(field string, word string, frequency int) queryMatches {
+	titles, ok := indexCache[fieldTerm(field, word)]
+	if !ok {
+		return queryMatches{}
+	}
+	documents, averageLength := collectionStats(field)
+	if documents < frequency {
+		documents = frequency
+	}
+	idf := bm25IDF(frequency, documents)
+	k1, b := bm25Params()
+	boost := fieldBoost(field)
+
+	matches := make(queryMatches, len(titles))
+	for k, v := range titles {
+		matches[k] = Pair{Title: k, Count: v, Score: boost * bm25Score(v, idf, documentLengths[field][k], averageLength, k1, b)}
+	}
+	return matches
+}
+
+// The terms a document must contain for the node to match, in query order, with phrases split into
+// their words. Negated terms are left out.
+func (node *queryNode) leaves() []*queryNode {
+	switch node.Kind {
+	case termQuery:
+		return []*queryNode{node}
+	case phraseQuery:
+		var leaves []*queryNode
+		for _, word := range node.Phrase {
+			if word != "" {
+				leaves = append(leaves, &queryNode{Kind: termQuery, Field: node.Field, Term: word})
+			}
+		}
+		return leaves
+	case notQuery, langQuery:
+		return nil
+	}
+	var leaves []*queryNode
+	for _, child := range node.Children {
+		leaves = append(leaves, child.leaves()...)
+	}
+	return leaves
+}
+
+// Every indexed document, for queries that only exclude
+func allDocuments() queryMatches {
+	matches := make(queryMatches, len(documentLengths[bodyField]))
+	for _, lengths := range documentLengths {
+		for info := range lengths {
+			matches[info] = Pair{Title: info}
+		}
+	}
+	return matches
+}
+
+func (matches queryMatches) subtract(excluded queryMatches) queryMatches {
+	for info := range excluded {
+		delete(matches, info)
+	}
+	return matches
+}
+
+func (pair Pair) add(other Pair) Pair {
+	return Pair{Title: other.Title, Count: pair.Count + other.Count, Score: pair.Score + other.Score}
+}
+
+// Ranks the pages matching a query and returns a page of them with the number of pages matching in all
+func searchQueryPage(query *queryNode, page searchPage) resultPage {
+	indexCashMutex.RLock()
+	defer indexCashMutex.RUnlock()
+	matches := query.evaluate()
+	if len(matches) == 0 {
+		return resultPage{}
+	}
+	var terms []string
+	for _, leaf := range query.leaves() {
+		terms = append(terms, fieldTerm(leaf.Field, leaf.Term))
+	}
+	pl := make(PairList, 0, len(matches))
+	for info, pair := range matches {
+		pair.Score += proximityBoost(terms, info) + pageRankBoost(info.URL)
+		pl = append(pl, pair)
+	}
+	pl = collapseDuplicates(pl)
+
+	results, more := pageResults(pl, page)
+	return resultPage{results, len(pl), more}
+}
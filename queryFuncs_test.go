@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func term(word string) *queryNode {
+	return &queryNode{Kind: termQuery, Term: word}
+}
+
+func TestParseQuery(t *testing.T) {
+	fixtures := []struct {
+		query  string
+		result *queryNode
+		err    bool
+	}{
+		{"golang", term("golang"), false},
+		{"GoLang", term("golang"), false},
+		{"golang concurrency", &queryNode{Kind: andQuery, Children: []*queryNode{term("golang"), term("concurrency")}}, false},
+		{"golang AND concurrency", &queryNode{Kind: andQuery, Children: []*queryNode{term("golang"), term("concurrency")}}, false},
+		{"golang OR rust", &queryNode{Kind: orQuery, Children: []*queryNode{term("golang"), term("rust")}}, false},
+		{"a b OR c", &queryNode{Kind: orQuery, Children: []*queryNode{
+			{Kind: andQuery, Children: []*queryNode{term("a"), term("b")}}, term("c")}}, false},
+		{"a (b OR c)", &queryNode{Kind: andQuery, Children: []*queryNode{
+			term("a"), {Kind: orQuery, Children: []*queryNode{term("b"), term("c")}}}}, false},
+		{"golang -java", &queryNode{Kind: andQuery, Children: []*queryNode{
+			term("golang"), {Kind: notQuery, Children: []*queryNode{term("java")}}}}, false},
+		{"golang NOT (java OR rust)", &queryNode{Kind: andQuery, Children: []*queryNode{
+			term("golang"), {Kind: notQuery, Children: []*queryNode{{Kind: orQuery, Children: []*queryNode{term("java"), term("rust")}}}}}}, false},
+		{"\"worker pools\" go", &queryNode{Kind: andQuery, Children: []*queryNode{
+			{Kind: phraseQuery, Phrase: []string{"worker", "pools"}}, term("go")}}, false},
+		{"\"OR\"", term("or"), false},
+		{"x-ray", &queryNode{Kind: phraseQuery, Phrase: []string{"x", "ray"}}, false},
+		{"Café", term("cafe"), false},
+		{"golang lang:DE-at", &queryNode{Kind: andQuery, Children: []*queryNode{term("golang"), {Kind: langQuery, Term: "de"}}}, false},
+		{"kubernets~", &queryNode{Kind: termQuery, Term: "kubernets", Fuzzy: 2}, false},
+		{"title:golang~1", &queryNode{Kind: termQuery, Field: titleField, Term: "golang", Fuzzy: 1}, false},
+		{"go~", term("go"), false},
+		{"golang~3", nil, true},
+		{"x-ray~", nil, true},
+		{"golang~ NEAR rust", nil, true},
+		{"lang:german", nil, true},
+		{"lang: golang", nil, true},
+		{"", nil, true},
+		{"   ", nil, true},
+		{"golang OR", nil, true},
+		{"(golang", nil, true},
+		{"golang)", nil, true},
+		{"\"golang", nil, true},
+		{"golang -", nil, true},
+		{"()", nil, true},
+		{"golang NEAR/3 concurrency", &queryNode{Kind: nearQuery, Children: []*queryNode{term("golang"), term("concurrency")}, Distance: 3}, false},
+		{"golang NEAR concurrency", &queryNode{Kind: nearQuery, Children: []*queryNode{term("golang"), term("concurrency")}, Distance: defaultNearDistance}, false},
+		{"a NEAR/2 b NEAR/2 c", &queryNode{Kind: nearQuery, Children: []*queryNode{term("a"), term("b"), term("c")}, Distance: 2}, false},
+		{"a NEAR/2 b NEAR/5 c", &queryNode{Kind: nearQuery, Children: []*queryNode{
+			{Kind: nearQuery, Children: []*queryNode{term("a"), term("b")}, Distance: 2}, term("c")}, Distance: 5}, false},
+		{"x \"worker pools\" NEAR/1 go", &queryNode{Kind: andQuery, Children: []*queryNode{term("x"),
+			{Kind: nearQuery, Children: []*queryNode{{Kind: phraseQuery, Phrase: []string{"worker", "pools"}}, term("go")}, Distance: 1}}}, false},
+		{"near", term("near"), false},
+		{"golang NEAR/x concurrency", nil, true},
+		{"golang NEAR/-1 concurrency", nil, true},
+		{"golang NEAR", nil, true},
+		{"golang NEAR/3 (a OR b)", nil, true},
+		{"golang NEAR/3 -java", nil, true},
+		{"title:Kubernetes", &queryNode{Kind: termQuery, Field: titleField, Term: "kubernetes"}, false},
+		{"title:\"worker pools\"", &queryNode{Kind: phraseQuery, Field: titleField, Phrase: []string{"worker", "pools"}}, false},
+		{"heading:(a OR body:b)", &queryNode{Kind: orQuery, Children: []*queryNode{
+			{Kind: termQuery, Field: headingField, Term: "a"}, {Kind: termQuery, Field: bodyField, Term: "b"}}}, false},
+		{"-anchor:a", &queryNode{Kind: notQuery, Children: []*queryNode{{Kind: termQuery, Field: anchorField, Term: "a"}}}, false},
+		{"http://x", &queryNode{Kind: phraseQuery, Phrase: []string{"http", "x"}}, false},
+		{"title:", nil, true},
+		{"(title:)", nil, true},
+	}
+	for _, fixture := range fixtures {
+		result, err := parseQuery(fixture.query)
+		if (err != nil) != fixture.err || !reflect.DeepEqual(result, fixture.result) {
+			t.Errorf("Expected %q to parse to %+v but received %+v (%v)", fixture.query, fixture.result, result, err)
+		}
+	}
+}
+
+func TestSearchQuery(t *testing.T) {
+	corpus := map[string]string{
+		"test.com/go":     "golang concurrency with goroutines and channels",
+		"test.com/java":   "java concurrency with threads and golang comparisons",
+		"test.com/rust":   "rust concurrency with ownership",
+		"test.com/garden": "tomatoes and basil",
+	}
+	clearIndex()
+	defer clearIndex()
+	for URL, text := range corpus {
+		indexTestDocument(indexCacheInfo{URL, URL}, text)
+	}
+
+	fixtures := []struct {
+		query string
+		URLs  []string
+	}{
+		{"golang concurrency", []string{"test.com/go", "test.com/java"}},
+		{"golang -java", []string{"test.com/go"}},
+		{"golang NOT java", []string{"test.com/go"}},
+		{"rust OR tomatoes", []string{"test.com/garden", "test.com/rust"}},
+		{"concurrency (rust OR goroutines)", []string{"test.com/go", "test.com/rust"}},
+		{"-concurrency", []string{"test.com/garden"}},
+		{"\"golang concurrency\"", []string{"test.com/go"}},
+		{"\"concurrency golang\"", nil},
+		{"\"with goroutines and\" channels", []string{"test.com/go"}},
+		{"concurrency NEAR/0 with", []string{"test.com/go", "test.com/java", "test.com/rust"}},
+		{"golang NEAR/1 threads", []string{"test.com/java"}},
+		{"golang NEAR/0 threads", nil},
+		{"rust NEAR/5 goroutines", nil},
+		{"golang NEAR/1 \"with goroutines\"", []string{"test.com/go"}},
+		{"java NEAR/1 concurrency NEAR/1 threads", []string{"test.com/java"}},
+		{"golang kubernetes", nil},
+		{"kubernetes OR basil", []string{"test.com/garden"}},
+	}
+	for _, fixture := range fixtures {
+		query, err := parseQuery(fixture.query)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		var URLs []string
+		for _, pair := range searchQuery(query) {
+			URLs = append(URLs, pair.Title.URL)
+		}
+		sort.Strings(URLs)
+		if !reflect.DeepEqual(URLs, fixture.URLs) {
+			t.Errorf("Expected %q to match %v but received %v", fixture.query, fixture.URLs, URLs)
+		}
+	}
+
+	//Matching more of an OR ranks a page higher
+	query, _ := parseQuery("goroutines OR channels OR threads")
+	if results := searchQuery(query); len(results) != 2 || results[0].Title.URL != "test.com/go" || results[0].Count != 2 {
+		t.Error("Expected the page matching two terms to rank first but received", results)
+	}
+}
+
+func TestProximityBoost(t *testing.T) {
+	clearIndex()
+	defer clearIndex()
+	indexTestDocument(indexCacheInfo{"Apart", "test.com/apart"}, "worker threads are covered in another chapter about pools")
+	indexTestDocument(indexCacheInfo{"Together", "test.com/together"}, "another chapter is about worker pools and threads we cover")
+
+	query, _ := parseQuery("worker pools")
+	results := searchQuery(query)
+	if len(results) != 2 || results[0].Title.URL != "test.com/together" {
+		t.Error("Expected the page with the words together to rank first but received", results)
+	}
+	if results[0].Score-results[1].Score < proximityWeight/2 {
+		t.Error("Expected adjacent words to be boosted but received", results)
+	}
+}
+
+// Runs a parsed query against the index and ranks every matching document
+func searchQuery(query *queryNode) PairList {
+	return searchQueryPage(query, searchPage{}).Results
+}
+
+func indexTestDocument(info indexCacheInfo, text string) {
+	words := strings.Fields(text)
+	counts, _ := mapReduceWords(words)
+	replaceDocument(walRecord{Info: info, Counts: counts, Positions: wordPositions(words), Words: surfaceWords(textAnalyzer, text)})
+}
+
+func TestSearchHandler(t *testing.T) {
+	clearIndex()
+	defer clearIndex()
+	updateCache(map[string]int{"golang": 1}, indexCacheInfo{"Go", "test.com/go"})
+
+	recorder := httptest.NewRecorder()
+	searchHandler(recorder, httptest.NewRequest("GET", "/search?q=golang+-java", nil))
+	var response searchResponse
+	json.NewDecoder(recorder.Body).Decode(&response)
+	if recorder.Code != http.StatusOK || len(response.Results) != 1 || response.Results[0].Title.URL != "test.com/go" {
+		t.Error("Expected the query to find the golang page but received", recorder.Code, response)
+	}
+
+	recorder = httptest.NewRecorder()
+	searchHandler(recorder, httptest.NewRequest("GET", "/search?q=(golang", nil))
+	if recorder.Code != http.StatusUnprocessableEntity {
+		t.Error("Expected an invalid query to be rejected but received", recorder.Code)
+	}
+}
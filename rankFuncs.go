@@ -0,0 +1,63 @@
+package main
+
+import "math"
+
+const defaultBM25K1 = 1.2
+const defaultBM25B = 0.75
+
+// Number of words indexed in each field of each document and their total per field, kept alongside
+// indexCache for BM25 length normalisation
+var documentLengths = map[string]map[indexCacheInfo]int{}
+var totalDocumentLength = map[string]int{}
+
+func bm25Params() (float64, float64) {
+	k1, b := defaultBM25K1, defaultBM25B
+	if configuration.BM25K1 != nil {
+		k1 = *configuration.BM25K1
+	}
+	if configuration.BM25B != nil {
+		b = *configuration.BM25B
+	}
+	return k1, b
+}
+
+// Inverse document frequency as used by Lucene, which stays positive even for words on most pages
+func bm25IDF(documentFrequency int, documents int) float64 {
+	return math.Log(1 + (float64(documents)-float64(documentFrequency)+0.5)/(float64(documentFrequency)+0.5))
+}
+
+// Scores one term in one document. A document of unknown length is treated as average length.
+func bm25Score(termFrequency int, idf float64, length int, averageLength float64, k1 float64, b float64) float64 {
+	normalisedLength := 1.0
+	if length > 0 && averageLength > 0 {
+		normalisedLength = float64(length) / averageLength
+	}
+	tf := float64(termFrequency)
+	return idf * tf * (k1 + 1) / (tf + k1*(1-b+b*normalisedLength))
+}
+
+// Collection statistics for BM25 within one field; callers must hold indexCashMutex
+func collectionStats(field string) (int, float64) {
+	documents := len(documentLengths[field])
+	if documents == 0 {
+		return 0, 0
+	}
+	return documents, float64(totalDocumentLength[field]) / float64(documents)
+}
+
+func setDocumentLength(field string, info indexCacheInfo, length int) {
+	totalDocumentLength[field] += length - documentLengths[field][info]
+	if length == 0 {
+		delete(documentLengths[field], info)
+		return
+	}
+	if documentLengths[field] == nil {
+		documentLengths[field] = make(map[indexCacheInfo]int)
+	}
+	documentLengths[field][info] = length
+}
+
+func clearDocumentLengths() {
+	documentLengths = make(map[string]map[indexCacheInfo]int)
+	totalDocumentLength = make(map[string]int)
+}
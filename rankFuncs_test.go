@@ -0,0 +1,118 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// A small fixed corpus with known answers, indexed the way a crawl would index it
+var relevanceCorpus = []struct {
+	info indexCacheInfo
+	text string
+}{
+	{indexCacheInfo{"Go Concurrency", "test.com/go"}, "go channels and goroutines make concurrency in go simple"},
+	{indexCacheInfo{"Gardening", "test.com/garden"}, "planting tomatoes in spring needs sun water and patience"},
+	{indexCacheInfo{"Go Everywhere", "test.com/spam"}, "go go go go go go go go go go go go tomatoes go go go go go go go go go go go go go go " +
+		"go go go go go go go go go go go go go go go go go go go go go go go go go go go go go go"},
+	{indexCacheInfo{"Tomato Soup", "test.com/soup"}, "tomatoes onions garlic and fresh basil simmered slowly for an hour make a rich and warming soup"},
+	{indexCacheInfo{"Channels", "test.com/channels"}, "channels"},
+	{indexCacheInfo{"Rust Ownership", "test.com/rust"}, "ownership and borrowing keep rust programs free of data races without a garbage collector"},
+}
+
+func indexRelevanceCorpus(t *testing.T) {
+	if err := clearIndex(); err != nil {
+		t.Fatal(err)
+	}
+	for _, document := range relevanceCorpus {
+		counts, _ := mapReduceWords(strings.Fields(document.text))
+		if _, err := updateCache(counts, document.info); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBM25Relevance(t *testing.T) {
+	indexRelevanceCorpus(t)
+	defer clearIndex()
+
+	fixtures := []struct {
+		word   string
+		ranked []string
+	}{
+		//A single mention in a one word page beats a mention in a longer page
+		{"channels", []string{"test.com/channels", "test.com/go"}},
+		//Repeating a word saturates, so a long page of nothing else does not bury a focused one by much
+		{"go", []string{"test.com/spam", "test.com/go"}},
+		//With one mention each, the shorter page is more about the word
+		{"tomatoes", []string{"test.com/garden", "test.com/soup", "test.com/spam"}},
+		{"rust", []string{"test.com/rust"}},
+	}
+	for _, fixture := range fixtures {
+		results := searchIndexForWord(fixture.word)
+		if len(results) != len(fixture.ranked) {
+			t.Errorf("Expected %d results for %s but received %v", len(fixture.ranked), fixture.word, results)
+			continue
+		}
+		for i, URL := range fixture.ranked {
+			if results[i].Title.URL != URL {
+				t.Errorf("Expected %s to rank %s at %d but received %v", fixture.word, URL, i, results)
+			}
+			if results[i].Score <= 0 {
+				t.Errorf("Expected a positive score for %s but received %f", URL, results[i].Score)
+			}
+		}
+	}
+
+	go1 := searchIndexForWord("go")
+	if go1[0].Score > 2*go1[1].Score {
+		t.Errorf("Expected term frequency to saturate but %f is more than double %f", go1[0].Score, go1[1].Score)
+	}
+}
+
+func TestBM25Parameters(t *testing.T) {
+	indexRelevanceCorpus(t)
+	defer clearIndex()
+	defer func() { configuration.BM25K1, configuration.BM25B = nil, nil }()
+
+	//Without length normalisation the one word page loses its advantage and counts decide
+	k1, b := 1.2, 0.0
+	configuration.BM25K1, configuration.BM25B = &k1, &b
+	results := searchIndexForWord("channels")
+	if math.Abs(results[0].Score-results[1].Score) > 1e-9 {
+		t.Error("Expected equal scores when b is 0 but received", results)
+	}
+
+	//With k1 at 0 term frequency is ignored entirely
+	k1, b = 0, 0.75
+	results = searchIndexForWord("go")
+	if math.Abs(results[0].Score-results[1].Score) > 1e-9 {
+		t.Error("Expected equal scores when k1 is 0 but received", results)
+	}
+}
+
+func TestDocumentLengths(t *testing.T) {
+	indexRelevanceCorpus(t)
+	defer clearIndex()
+
+	info := relevanceCorpus[1].info
+	if documentLengths[bodyField][info] != 9 {
+		t.Error("Expected the gardening page to be 9 words long but found", documentLengths[bodyField][info])
+	}
+	total := 0
+	for _, document := range relevanceCorpus {
+		total += len(strings.Fields(document.text))
+	}
+	if totalDocumentLength[bodyField] != total {
+		t.Errorf("Expected a total length of %d but found %d", total, totalDocumentLength[bodyField])
+	}
+
+	removeDocument(info)
+	if _, found := documentLengths[bodyField][info]; found || totalDocumentLength[bodyField] != total-9 {
+		t.Error("Expected removing a page to drop its length but found", totalDocumentLength)
+	}
+	clearIndex()
+	if len(documentLengths) != 0 || len(totalDocumentLength) != 0 {
+		t.Error("Expected clearing the index to reset document lengths")
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A page is first revisited after a day, then twice as often each time it has changed and half as often
+// each time it has not, within MinRevisit and MaxRevisit
+const defaultRevisit = 24 * time.Hour
+const defaultMinRevisit = time.Hour
+const defaultMaxRevisit = 30 * 24 * time.Hour
+
+// The most pages one recrawl fetches; the rest wait for the next
+const maxRecrawlPages = 1000
+
+func revisitBounds() (time.Duration, time.Duration) {
+	minimum, maximum := defaultMinRevisit, defaultMaxRevisit
+	if configuration.MinRevisit > 0 {
+		minimum = time.Duration(configuration.MinRevisit) * time.Second
+	}
+	if configuration.MaxRevisit > 0 {
+		maximum = time.Duration(configuration.MaxRevisit) * time.Second
+	}
+	if maximum < minimum {
+		maximum = minimum
+	}
+	return minimum, maximum
+}
+
+// How long to wait before fetching a page again, given how long was waited last time and whether
+// the page changed in between. A page seen for the first time has no previous interval.
+func nextRevisit(previous time.Duration, changed bool) time.Duration {
+	minimum, maximum := revisitBounds()
+	next := defaultRevisit
+	if previous > 0 && changed {
+		next = previous / 2
+	} else if previous > 0 {
+		next = previous * 2
+	}
+	if next < minimum {
+		return minimum
+	}
+	if next > maximum {
+		return maximum
+	}
+	return next
+}
+
+// How long to wait before fetching a page indexed with the checksum again, given what was stored for it before
+func revisitAfter(stored documentMetadata, known bool, checksum uint64) time.Duration {
+	if !known {
+		return nextRevisit(0, true)
+	}
+	return nextRevisit(stored.Interval, checksum != stored.Checksum)
+}
+
+func bodyChecksum(body string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(body))
+	return hash.Sum64()
+}
+
+// Returns what is stored for the document a URL would be indexed under, if it is indexed
+func storedDocument(URI string) (indexCacheInfo, documentMetadata, bool) {
+	canonical, err := canonicalURL(URI)
+	if err != nil {
+		return indexCacheInfo{}, documentMetadata{}, false
+	}
+	indexCashMutex.RLock()
+	defer indexCashMutex.RUnlock()
+	info, ok := documentsByURL[canonical]
+	return info, documentStore[canonical], ok
+}
+
+// Fetches a page, asking the server to answer 304 Not Modified if it has not changed since it was stored
+func getConditionalRequest(ctx context.Context, uri string, stored documentMetadata) (*http.Response, error) {
+	req, err := newCrawlRequest(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	if stored.ETag != "" {
+		req.Header.Set("If-None-Match", stored.ETag)
+	}
+	if stored.LastModified != "" {
+		req.Header.Set("If-Modified-Since", stored.LastModified)
+	}
+	return crawlClient.Do(req)
+}
+
+// Records that a page was fetched again and found unchanged, so it is revisited less often without being re-indexed
+func keepDocument(info indexCacheInfo, stored documentMetadata, resp *http.Response, fetched time.Time) error {
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		stored.ETag = etag
+	}
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		stored.LastModified = lastModified
+	}
+	return backOffDocument(info, stored, fetched)
+}
+
+// Records that a page was fetched again without being re-indexed, leaving it twice as long before the next try
+func backOffDocument(info indexCacheInfo, stored documentMetadata, fetched time.Time) error {
+	stored.Fetched = fetched
+	stored.Interval = nextRevisit(stored.Interval, false)
+	return logAndApply(walRecord{Op: walTouch, Info: info, Metadata: &stored})
+}
+
+// The URLs of documents due to be fetched again, the longest overdue first
+func dueDocuments(now time.Time) []string {
+	type due struct {
+		URL  string
+		next time.Time
+	}
+	var pages []due
+	indexCashMutex.RLock()
+	for URL, metadata := range documentStore {
+		//Pages indexed before revisits were tracked are left alone
+		if metadata.Interval == 0 {
+			continue
+		}
+		if next := metadata.Fetched.Add(metadata.Interval); !next.After(now) {
+			pages = append(pages, due{URL, next})
+		}
+	}
+	indexCashMutex.RUnlock()
+
+	sort.Slice(pages, func(i, j int) bool {
+		if !pages[i].next.Equal(pages[j].next) {
+			return pages[i].next.Before(pages[j].next)
+		}
+		return pages[i].URL < pages[j].URL
+	})
+	if len(pages) > maxRecrawlPages {
+		pages = pages[:maxRecrawlPages]
+	}
+	URLs := make([]string, len(pages))
+	for i, page := range pages {
+		URLs[i] = page.URL
+	}
+	return URLs
+}
+
+// Starts a job fetching the documents that are due again, or returns nil when none are
+func startRecrawl(now time.Time) *crawlJob {
+	URLs := dueDocuments(now)
+	if len(URLs) == 0 {
+		return nil
+	}
+	job := newCrawlJob(crawlRequest{})
+	job.pages = URLs
+	job.recrawl = true
+	fmt.Println("Recrawling", len(URLs), "pages as job", job.ID)
+	return job
+}
+
+// Looks for documents due to be fetched again every interval and recrawls them, one job at a time.
+// Returns a function that stops it, cancelling any recrawl under way, and waits for it to finish.
+func startRecrawler(interval time.Duration) func() {
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				job := startRecrawl(time.Now())
+				if job == nil {
+					continue
+				}
+				done := make(chan struct{})
+				go func() {
+					runCrawlJob(job)
+					close(done)
+				}()
+				select {
+				case <-done:
+				case <-stop:
+					job.cancel()
+					<-done
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// Fetches and indexes just the job's pages, keeping to the same host limits as a crawl
+func refetchPages(ctx context.Context, job *crawlJob) {
+	schedule := newFrontier(configuration.MaxParallel, configuration.MaxPerHost, time.Duration(configuration.HostDelay)*time.Millisecond)
+	defer schedule.close()
+	queued := 0
+	for _, URL := range job.pages {
+		if schedule.push(URL, 0, 0) {
+			queued++
+		}
+	}
+
+	var fetches sync.WaitGroup
+	for ; queued > 0; queued-- {
+		item, ok := schedule.next(ctx)
+		if !ok {
+			break
+		}
+		fetches.Add(1)
+		go func() {
+			defer fetches.Done()
+			defer schedule.release(item)
+			refetchPage(ctx, job, schedule, item)
+		}()
+	}
+	fetches.Wait()
+}
+
+func refetchPage(ctx context.Context, job *crawlJob, schedule *frontier, item frontierItem) {
+	robots, err := getRobots(ctx, item.URI)
+	if err != nil {
+		return
+	}
+	schedule.setCrawlDelay(item.host, robots.FindGroup(configuration.CrawlerAgent).CrawlDelay)
+	if !robotsAllow(robots, item.URI) {
+		fmt.Println("Cannot Legally Crawl Link ", item.URI)
+		job.emit(crawlEvent{Type: eventRobotsSkipped, URL: item.URI})
+		return
+	}
+	page, err := indexPage(ctx, job, Crawler{item.URI, 0})
+	if err != nil {
+		if ctx.Err() == nil {
+			fmt.Println("Error fetching", item.URI, err)
+			job.recordError(err)
+			job.emit(crawlEvent{Type: eventFetchError, URL: item.URI, Error: err.Error()})
+		}
+		return
+	}
+	job.recordPage(page.Result)
+}
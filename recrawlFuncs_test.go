@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNextRevisit(t *testing.T) {
+	fixtures := []struct {
+		previous   time.Duration
+		changed    bool
+		minRevisit int
+		maxRevisit int
+		next       time.Duration
+	}{
+		{0, true, 0, 0, 24 * time.Hour},
+		{24 * time.Hour, true, 0, 0, 12 * time.Hour},
+		{24 * time.Hour, false, 0, 0, 48 * time.Hour},
+		{90 * time.Minute, true, 0, 0, time.Hour},
+		{20 * 24 * time.Hour, false, 0, 0, 30 * 24 * time.Hour},
+		{0, true, 60, 600, 10 * time.Minute},
+		{4 * time.Minute, false, 60, 600, 8 * time.Minute},
+		{time.Minute, true, 60, 600, time.Minute},
+	}
+	defer func() { configuration.MinRevisit, configuration.MaxRevisit = 0, 0 }()
+	for _, fixture := range fixtures {
+		configuration.MinRevisit, configuration.MaxRevisit = fixture.minRevisit, fixture.maxRevisit
+		if next := nextRevisit(fixture.previous, fixture.changed); next != fixture.next {
+			t.Errorf("Expected %v changed %v to be revisited after %v but received %v", fixture.previous, fixture.changed, fixture.next, next)
+		}
+	}
+}
+
+func TestRecrawl(t *testing.T) {
+	version := "v1"
+	content := "golang"
+	var conditional []string
+	var mutex sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch r.URL.Path {
+		case "/robots.txt":
+			http.NotFound(w, r)
+		case "/dated":
+			conditional = append(conditional, r.Header.Get("If-Modified-Since"))
+			if r.Header.Get("If-Modified-Since") == "Mon, 02 Jan 2006 15:04:05 GMT" {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+			fmt.Fprint(w, "<html><head><title>Dated</title></head><body><p>dated</p></body></html>")
+		case "/static":
+			fmt.Fprint(w, "<html><head><title>Static</title></head><body><p>static</p></body></html>")
+		default:
+			conditional = append(conditional, r.Header.Get("If-None-Match"))
+			if r.Header.Get("If-None-Match") == "\""+version+"\"" {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", "\""+version+"\"")
+			fmt.Fprint(w, "<html><head><title>Page</title></head><body><p>"+content+"</p><a href=\"/dated\">dated</a> <a href=\"/static\">static</a></body></html>")
+		}
+	}))
+	defer server.Close()
+
+	configuration.MaxDepth = 2
+	configuration.MaxParallel = 1
+	clearIndex()
+	defer clearIndex()
+	runCrawlJob(newCrawlJob(crawlRequest{URL: server.URL + "/"}))
+	revisit := func(URL string) time.Duration {
+		document, ok := getDocument(documentID(server.URL + URL))
+		if !ok || document.NextFetch == nil {
+			t.Fatal("Expected", URL, "to be indexed with a time to fetch it again")
+		}
+		return document.NextFetch.Sub(document.Fetched)
+	}
+
+	if job := startRecrawl(time.Now()); job != nil {
+		t.Error("Expected no pages to be due straight after they were crawled but received", job.pages)
+	}
+
+	//Nothing has changed, so every page is kept as it is and left twice as long
+	job := startRecrawl(time.Now().Add(25 * time.Hour))
+	if job == nil || len(job.pages) != 3 {
+		t.Fatal("Expected every page to be due a day later but received", job)
+	}
+	runCrawlJob(job)
+	events, _ := job.eventsSince(0)
+	unchanged := 0
+	for _, event := range events {
+		if event.Type == eventNotModified {
+			unchanged++
+		}
+	}
+	mutex.Lock()
+	sent := map[string]bool{}
+	for _, validator := range conditional[minInt(2, len(conditional)):] {
+		sent[validator] = true
+	}
+	if len(conditional) != 4 || !sent["Mon, 02 Jan 2006 15:04:05 GMT"] || !sent["\"v1\""] {
+		t.Error("Expected the recrawl to send the stored validators but received", conditional)
+	}
+	mutex.Unlock()
+	if unchanged != 3 || job.snapshot().PagesFetched != 0 {
+		t.Error("Expected no page to be re-indexed but received", events)
+	}
+	for _, URL := range []string{"/", "/dated", "/static"} {
+		if interval := revisit(URL); interval != 48*time.Hour {
+			t.Error("Expected", URL, "to be left for two days but received", interval)
+		}
+	}
+
+	//A changed page is re-indexed and fetched again sooner
+	mutex.Lock()
+	version, content = "v2", "rust"
+	mutex.Unlock()
+	job = startRecrawl(time.Now().Add(49 * time.Hour))
+	runCrawlJob(job)
+	if job.snapshot().PagesFetched != 1 {
+		t.Error("Expected only the changed page to be re-indexed but received", job.snapshot())
+	}
+	if len(searchIndexForWord("golang")) != 0 || len(searchIndexForWord("rust")) != 1 {
+		t.Error("Expected the changed page's new words to replace its old ones")
+	}
+	if interval := revisit("/"); interval != 24*time.Hour {
+		t.Error("Expected the changed page to be fetched again after a day but received", interval)
+	}
+	if interval := revisit("/static"); interval != 4*24*time.Hour {
+		t.Error("Expected the unchanged page to be left for four days but received", interval)
+	}
+}
+
+func TestRecrawlServerError(t *testing.T) {
+	failing := false
+	var mutex sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch {
+		case r.URL.Path == "/robots.txt":
+			http.NotFound(w, r)
+		case failing:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, "<html><head><title>Maintenance</title></head><body><p>unavailable</p></body></html>")
+		default:
+			fmt.Fprint(w, "<html><head><title>Page</title></head><body><p>golang</p></body></html>")
+		}
+	}))
+	defer server.Close()
+
+	configuration.MaxDepth = 2
+	configuration.MaxParallel = 1
+	clearIndex()
+	defer clearIndex()
+	runCrawlJob(newCrawlJob(crawlRequest{URL: server.URL + "/"}))
+
+	mutex.Lock()
+	failing = true
+	mutex.Unlock()
+	job := startRecrawl(time.Now().Add(25 * time.Hour))
+	if job == nil {
+		t.Fatal("Expected the page to be due a day later")
+	}
+	runCrawlJob(job)
+	events, _ := job.eventsSince(0)
+	if len(job.snapshot().Errors) != 1 || len(events) == 0 || events[0].Type != eventFetchError {
+		t.Error("Expected the failed fetch to be recorded as an error but received", job.snapshot().Errors, events)
+	}
+	if len(searchIndexForWord("golang")) != 1 || len(searchIndexForWord("unavailable")) != 0 {
+		t.Error("Expected the stored page to be kept rather than replaced by the error page")
+	}
+	document, ok := getDocument(documentID(server.URL + "/"))
+	if !ok || document.Title != "Page" || document.NextFetch == nil || document.NextFetch.Sub(document.Fetched) != 48*time.Hour {
+		t.Error("Expected the page to be kept and tried again after two days but received", document)
+	}
+}
+
+func TestStopRecrawler(t *testing.T) {
+	fetching := make(chan struct{}, 1)
+	var mutex sync.Mutex
+	fetched := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		mutex.Lock()
+		again := fetched
+		fetched = true
+		mutex.Unlock()
+		if again {
+			//The recrawl hangs until it is cancelled
+			fetching <- struct{}{}
+			<-r.Context().Done()
+			return
+		}
+		fmt.Fprint(w, "<html><head><title>Page</title></head><body><p>golang</p></body></html>")
+	}))
+	defer server.Close()
+
+	configuration.MaxDepth = 1
+	configuration.MaxParallel = 1
+	clearIndex()
+	defer clearIndex()
+	runCrawlJob(newCrawlJob(crawlRequest{URL: server.URL + "/"}))
+	indexCashMutex.Lock()
+	for URL, metadata := range documentStore {
+		metadata.Interval = time.Millisecond
+		documentStore[URL] = metadata
+	}
+	indexCashMutex.Unlock()
+
+	stop := startRecrawler(time.Millisecond)
+	<-fetching
+	stop()
+	for _, job := range listJobs() {
+		if job.Status == jobRunning {
+			t.Error("Expected stopping the recrawler to wait for its job to be cancelled but found", job.ID, "running")
+		}
+	}
+	if len(searchIndexForWord("golang")) != 1 {
+		t.Error("Expected the page to be kept when its recrawl was cancelled")
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/temoto/robotstxt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RFC 9309 requires parsing at least the first 500 KiB of a robots.txt
+const maxRobotsSize = 500 * 1024
+
+// After this long unreachable the last successfully fetched rules are used again, or nothing is disallowed
+const maxRobotsUnreachable = 30 * 24 * time.Hour
+
+type robotsEntry struct {
+	Origin           string
+	StatusCode       int
+	Body             string
+	Error            string `json:",omitempty"`
+	Sitemaps         []string
+	CrawlDelay       float64
+	FetchedAt        time.Time
+	ExpiresAt        time.Time
+	UnreachableSince *time.Time `json:",omitempty"`
+
+	data      *robotstxt.RobotsData
+	lastGood  *robotsEntry
+	fetchDone chan struct{}
+}
+
+var robotsCache = map[string]*robotsEntry{}
+var robotsCacheMutex = sync.Mutex{}
+
+func robotsOrigin(URL string) (string, error) {
+	parsedUrl, err := url.Parse(URL)
+	if err != nil {
+		return "", err
+	}
+	if parsedUrl.Scheme == "" || parsedUrl.Host == "" {
+		return "", fmt.Errorf("Cannot find host for %s", URL)
+	}
+	return parsedUrl.Scheme + "://" + parsedUrl.Host, nil
+}
+
+// How long robots.txt is cached, and how long a host whose robots.txt failed is disallowed before trying again
+const defaultRobotsTTL = 24 * time.Hour
+const defaultRobotsRetry = 5 * time.Minute
+
+// Seconds from config, the default when left out and none when negative
+func robotsSeconds(seconds int, fallback time.Duration) time.Duration {
+	if seconds == 0 {
+		return fallback
+	}
+	if seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func robotsTTL() time.Duration {
+	return robotsSeconds(configuration.RobotsTTL, defaultRobotsTTL)
+}
+
+func robotsRetry() time.Duration {
+	return robotsSeconds(configuration.RobotsRetry, defaultRobotsRetry)
+}
+
+// Returns the robots.txt rules for the URL's host, fetching them only when the cached copy has expired.
+// Concurrent callers for the same host share a single fetch.
+func getRobots(ctx context.Context, URL string) (*robotstxt.RobotsData, error) {
+	origin, err := robotsOrigin(URL)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		robotsCacheMutex.Lock()
+		entry, ok := robotsCache[origin]
+		if ok && entry.fetchDone != nil {
+			robotsCacheMutex.Unlock()
+			select {
+			case <-entry.fetchDone:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if ok && time.Now().Before(entry.ExpiresAt) {
+			robotsCacheMutex.Unlock()
+			return entry.data, nil
+		}
+		pending := &robotsEntry{Origin: origin, fetchDone: make(chan struct{})}
+		if ok {
+			pending.lastGood = entry.lastGood
+			pending.UnreachableSince = entry.UnreachableSince
+		}
+		robotsCache[origin] = pending
+		robotsCacheMutex.Unlock()
+
+		fetched, err := fetchRobots(ctx, pending)
+		robotsCacheMutex.Lock()
+		if err != nil {
+			//A cancelled crawl says nothing about the host, so put back what was cached before
+			if ok {
+				robotsCache[origin] = entry
+			} else {
+				delete(robotsCache, origin)
+			}
+		} else {
+			robotsCache[origin] = fetched
+		}
+		close(pending.fetchDone)
+		robotsCacheMutex.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		return fetched.data, nil
+	}
+}
+
+// Fetches robots.txt and applies the RFC 9309 status rules: 2xx is parsed, 4xx allows everything,
+// and 5xx or an unreachable host disallows everything until it is retried
+func fetchRobots(ctx context.Context, previous *robotsEntry) (*robotsEntry, error) {
+	now := time.Now()
+	entry := &robotsEntry{Origin: previous.Origin, FetchedAt: now, lastGood: previous.lastGood}
+
+	req, err := newCrawlRequest(ctx, previous.Origin+"/robots.txt")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := crawlClient.Do(req)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	var body []byte
+	if err == nil {
+		entry.StatusCode = resp.StatusCode
+		body, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxRobotsSize))
+		resp.Body.Close()
+		if err != nil && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	if err == nil {
+		entry.Body = string(body)
+		entry.data, err = robotstxt.FromStatusAndBytes(entry.StatusCode, body)
+	}
+
+	switch {
+	case err != nil || entry.StatusCode >= 500:
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		entry.UnreachableSince = previous.UnreachableSince
+		if entry.UnreachableSince == nil {
+			entry.UnreachableSince = &now
+		}
+		entry.data, _ = robotstxt.FromStatusAndBytes(http.StatusServiceUnavailable, nil)
+		if now.Sub(*entry.UnreachableSince) > maxRobotsUnreachable {
+			if entry.lastGood != nil {
+				entry.data = entry.lastGood.data
+			} else {
+				entry.data, _ = robotstxt.FromStatusAndBytes(http.StatusNotFound, nil)
+			}
+		}
+		entry.ExpiresAt = now.Add(robotsRetry())
+		fmt.Println("robots.txt for", entry.Origin, "is unavailable, disallowing until", entry.ExpiresAt)
+	default:
+		entry.ExpiresAt = now.Add(robotsTTL())
+		entry.Sitemaps = entry.data.Sitemaps
+		entry.CrawlDelay = entry.data.FindGroup(configuration.CrawlerAgent).CrawlDelay.Seconds()
+		entry.lastGood = entry
+	}
+	return entry, nil
+}
+
+func robotsAllow(data *robotstxt.RobotsData, URL string) bool {
+	parsedUrl, err := url.Parse(URL)
+	if err != nil {
+		return false
+	}
+	return data.TestAgent(parsedUrl.RequestURI(), configuration.CrawlerAgent)
+}
+
+func listRobots() []robotsEntry {
+	robotsCacheMutex.Lock()
+	defer robotsCacheMutex.Unlock()
+	entries := []robotsEntry{}
+	for _, entry := range robotsCache {
+		if entry.fetchDone == nil {
+			entries = append(entries, *entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Origin < entries[j].Origin })
+	return entries
+}
+
+func clearRobots() {
+	robotsCacheMutex.Lock()
+	robotsCache = make(map[string]*robotsEntry)
+	robotsCacheMutex.Unlock()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRobotsCache(t *testing.T) {
+	fetches := 0
+	var agent string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		agent = r.Header.Get("User-Agent")
+		w.WriteHeader(status)
+		fmt.Fprint(w, "User-Agent: * \nDisallow: /private\nCrawl-delay: 2\nSitemap: http://sitemap.test/sitemap.xml")
+	}))
+	defer server.Close()
+
+	configuration.CrawlerAgent = "kgp-test"
+	configuration.RobotsTTL = 3600
+	defer func() { configuration.CrawlerAgent = ""; configuration.RobotsTTL = 0 }()
+	clearRobots()
+	defer clearRobots()
+
+	fixtures := []struct {
+		URL    string
+		result bool
+	}{
+		{server.URL + "/public", true},
+		{server.URL + "/private/page", false},
+		{server.URL + "/public?private", true},
+	}
+	for _, fixture := range fixtures {
+		if canCrawl(fixture.URL) != fixture.result {
+			t.Error("Unexpected robots result for", fixture.URL)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("Expected robots.txt to be fetched once but it was fetched %d times", fetches)
+	}
+	if agent != "kgp-test" {
+		t.Error("Expected robots.txt to be fetched with the configured User-Agent but received", agent)
+	}
+
+	entries := listRobots()
+	if len(entries) != 1 || entries[0].Origin != server.URL || entries[0].CrawlDelay != 2 || len(entries[0].Sitemaps) != 1 {
+		t.Error("Unexpected cache entries", entries)
+	}
+}
+
+func TestRobotsDefaults(t *testing.T) {
+	fixtures := []struct {
+		TTL   int
+		retry int
+		ttl   time.Duration
+		again time.Duration
+	}{
+		{0, 0, 24 * time.Hour, 5 * time.Minute},
+		{60, 10, time.Minute, 10 * time.Second},
+		{-1, -1, 0, 0},
+	}
+	defer func() { configuration.RobotsTTL, configuration.RobotsRetry = 0, 0 }()
+	for _, fixture := range fixtures {
+		configuration.RobotsTTL, configuration.RobotsRetry = fixture.TTL, fixture.retry
+		if robotsTTL() != fixture.ttl || robotsRetry() != fixture.again {
+			t.Errorf("Expected RobotsTTL %d and RobotsRetry %d to give %v and %v but received %v and %v",
+				fixture.TTL, fixture.retry, fixture.ttl, fixture.again, robotsTTL(), robotsRetry())
+		}
+	}
+}
+
+func TestRobotsStatusCodes(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprint(w, "User-Agent: * \nDisallow: /private")
+	}))
+	defer server.Close()
+
+	configuration.RobotsTTL = 3600
+	configuration.RobotsRetry = -1
+	defer func() { configuration.RobotsTTL, configuration.RobotsRetry = 0, 0 }()
+
+	fixtures := []struct {
+		status int
+		URL    string
+		result bool
+	}{
+		{http.StatusOK, server.URL + "/private", false},
+		{http.StatusNotFound, server.URL + "/private", true},
+		{http.StatusUnauthorized, server.URL + "/private", true},
+		{http.StatusForbidden, server.URL + "/private", true},
+		{http.StatusInternalServerError, server.URL + "/public", false},
+		{http.StatusServiceUnavailable, server.URL + "/public", false},
+	}
+	for _, fixture := range fixtures {
+		clearRobots()
+		status = fixture.status
+		if canCrawl(fixture.URL) != fixture.result {
+			t.Error("Unexpected robots result for status", fixture.status)
+		}
+	}
+
+	//With no retry delay a server error is fetched again on the next check
+	status = http.StatusOK
+	if !canCrawl(server.URL + "/public") {
+		t.Error("Expected robots.txt to be retried after a server error")
+	}
+	clearRobots()
+}
+
+func TestRobotsUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	URL := server.URL + "/page"
+	server.Close()
+
+	configuration.RobotsRetry = 3600
+	defer func() { configuration.RobotsRetry = 0 }()
+	clearRobots()
+	defer clearRobots()
+
+	if canCrawl(URL) {
+		t.Error("Expected an unreachable host to be disallowed")
+	}
+	entries := listRobots()
+	if len(entries) != 1 || entries[0].UnreachableSince == nil || entries[0].Error == "" {
+		t.Error("Expected the unreachable host to be cached for retry", entries)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	clearRobots()
+	if _, err := getRobots(ctx, URL); err == nil {
+		t.Error("Expected a cancelled fetch to return an error")
+	}
+	if len(listRobots()) != 0 {
+		t.Error("A cancelled fetch should not be cached")
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"golang.org/x/net/publicsuffix"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Why a link was left out of a crawl, as counted in the job's Skipped
+const (
+	skippedHost        = "host"
+	skippedInclude     = "include"
+	skippedExclude     = "exclude"
+	skippedMaxPages    = "max_pages"
+	skippedMaxPerHost  = "max_pages_per_host"
+	skippedInvalidLink = "invalid"
+)
+
+// Limits on which links a crawl follows, given in the body of POST /index. A link's host is allowed when it
+// matches any of the host rules given, and any host is when none are.
+type crawlScope struct {
+	//Stay on the start URL's host, or on any host under its registrable domain such as example.co.uk
+	SameHost   bool `json:",omitempty"`
+	SameDomain bool `json:",omitempty"`
+	//Hosts that may be crawled, each along with its subdomains
+	AllowedHosts []string `json:",omitempty"`
+	//Patterns a link's URL must match one of, and must match none of
+	Include []string `json:",omitempty"`
+	Exclude []string `json:",omitempty"`
+	//The most pages queued for the whole crawl and for any one host; 0 is no limit
+	MaxPages        int `json:",omitempty"`
+	MaxPagesPerHost int `json:",omitempty"`
+}
+
+// A crawl's scope ready to check links against, counting the pages it has let through. Only used by the
+// goroutine running the crawl.
+type scopeRules struct {
+	scope   crawlScope
+	host    string
+	domain  string
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	queued  int
+	perHost map[string]int
+}
+
+// The registrable domain of a host, or the host itself when it has none, as with IP addresses and localhost
+func registrableDomain(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
+
+func hostOf(URI string) (string, error) {
+	parsed, err := url.Parse(URI)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(parsed.Hostname()), nil
+}
+
+// Compiles a crawl's scope, with the host rules relative to where it starts
+func newScopeRules(request crawlRequest) (*scopeRules, error) {
+	rules := &scopeRules{scope: request.Scope, perHost: make(map[string]int)}
+	if request.Scope.MaxPages < 0 || request.Scope.MaxPagesPerHost < 0 {
+		return nil, errors.New("MaxPages and MaxPagesPerHost must not be negative")
+	}
+	start := request.URL
+	if start == "" {
+		start = request.Sitemap
+	}
+	host, err := hostOf(start)
+	if err != nil {
+		return nil, err
+	}
+	rules.host, rules.domain = host, registrableDomain(host)
+	for _, pattern := range request.Scope.Include {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.New("Invalid Include pattern: " + err.Error())
+		}
+		rules.include = append(rules.include, compiled)
+	}
+	for _, pattern := range request.Scope.Exclude {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.New("Invalid Exclude pattern: " + err.Error())
+		}
+		rules.exclude = append(rules.exclude, compiled)
+	}
+	return rules, nil
+}
+
+func (rules *scopeRules) hostAllowed(host string) bool {
+	if !rules.scope.SameHost && !rules.scope.SameDomain && len(rules.scope.AllowedHosts) == 0 {
+		return true
+	}
+	if rules.scope.SameHost && host == rules.host {
+		return true
+	}
+	if rules.scope.SameDomain && registrableDomain(host) == rules.domain {
+		return true
+	}
+	for _, allowed := range rules.scope.AllowedHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns why the link is out of the crawl's scope, or "" when it may be queued
+func (rules *scopeRules) check(URI string) string {
+	host, err := hostOf(URI)
+	if err != nil {
+		return skippedInvalidLink
+	}
+	if !rules.hostAllowed(host) {
+		return skippedHost
+	}
+	if reason := rules.checkPatterns(URI); reason != "" {
+		return reason
+	}
+	return rules.checkLimits(host)
+}
+
+// Returns why the start link is out of the crawl's scope; Include and Exclude pick which links to follow
+// from it, so they are not held against the start link itself
+func (rules *scopeRules) checkSeed(URI string) string {
+	host, err := hostOf(URI)
+	if err != nil {
+		return skippedInvalidLink
+	}
+	if !rules.hostAllowed(host) {
+		return skippedHost
+	}
+	return rules.checkLimits(host)
+}
+
+func (rules *scopeRules) checkPatterns(URI string) string {
+	if len(rules.include) > 0 {
+		included := false
+		for _, pattern := range rules.include {
+			if pattern.MatchString(URI) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return skippedInclude
+		}
+	}
+	for _, pattern := range rules.exclude {
+		if pattern.MatchString(URI) {
+			return skippedExclude
+		}
+	}
+	return ""
+}
+
+func (rules *scopeRules) checkLimits(host string) string {
+	if rules.scope.MaxPages > 0 && rules.queued >= rules.scope.MaxPages {
+		return skippedMaxPages
+	}
+	if rules.scope.MaxPagesPerHost > 0 && rules.perHost[host] >= rules.scope.MaxPagesPerHost {
+		return skippedMaxPerHost
+	}
+	return ""
+}
+
+// Counts a link that was queued against the page limits
+func (rules *scopeRules) queue(URI string) {
+	host, _ := hostOf(URI)
+	rules.queued++
+	rules.perHost[host]++
+}
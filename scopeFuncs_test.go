@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRegistrableDomain(t *testing.T) {
+	fixtures := []struct {
+		host   string
+		domain string
+	}{
+		{"docs.example.com", "example.com"},
+		{"example.com", "example.com"},
+		{"www.bbc.co.uk", "bbc.co.uk"},
+		{"127.0.0.1", "127.0.0.1"},
+		{"localhost", "localhost"},
+	}
+	for _, fixture := range fixtures {
+		if domain := registrableDomain(fixture.host); domain != fixture.domain {
+			t.Errorf("Expected %q to be under %q but received %q", fixture.host, fixture.domain, domain)
+		}
+	}
+}
+
+func TestScopeRules(t *testing.T) {
+	fixtures := []struct {
+		scope  crawlScope
+		link   string
+		reason string
+	}{
+		{crawlScope{}, "http://github.com/golang/go", ""},
+		{crawlScope{SameHost: true}, "http://docs.example.com/guide", ""},
+		{crawlScope{SameHost: true}, "http://DOCS.example.com:8080/guide", ""},
+		{crawlScope{SameHost: true}, "http://blog.example.com/", skippedHost},
+		{crawlScope{SameDomain: true}, "http://blog.example.com/", ""},
+		{crawlScope{SameDomain: true}, "http://example.org/", skippedHost},
+		{crawlScope{AllowedHosts: []string{"github.com"}}, "http://gist.github.com/x", ""},
+		{crawlScope{AllowedHosts: []string{"github.com"}}, "http://notgithub.com/x", skippedHost},
+		{crawlScope{SameHost: true, AllowedHosts: []string{"github.com"}}, "http://github.com/x", ""},
+		{crawlScope{Include: []string{`/guide/`, `/api/`}}, "http://docs.example.com/api/v1", ""},
+		{crawlScope{Include: []string{`/guide/`, `/api/`}}, "http://docs.example.com/blog/", skippedInclude},
+		{crawlScope{Exclude: []string{`\.pdf$`}}, "http://docs.example.com/manual.pdf", skippedExclude},
+		{crawlScope{Include: []string{`/guide/`}, Exclude: []string{`/old/`}}, "http://docs.example.com/guide/old/", skippedExclude},
+		{crawlScope{MaxPages: 2}, "http://docs.example.com/next", skippedMaxPages},
+		{crawlScope{MaxPagesPerHost: 2}, "http://docs.example.com/next", skippedMaxPerHost},
+		{crawlScope{MaxPagesPerHost: 2}, "http://example.org/next", ""},
+		{crawlScope{SameHost: true}, "http://%zz", skippedInvalidLink},
+	}
+	for _, fixture := range fixtures {
+		rules, err := newScopeRules(crawlRequest{URL: "http://docs.example.com/", Scope: fixture.scope})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules.queue("http://docs.example.com/")
+		rules.queue("http://docs.example.com/guide")
+		if reason := rules.check(fixture.link); reason != fixture.reason {
+			t.Errorf("Expected %q with %+v to give %q but received %q", fixture.link, fixture.scope, fixture.reason, reason)
+		}
+	}
+
+	if _, err := newScopeRules(crawlRequest{URL: "http://docs.example.com/", Scope: crawlScope{Include: []string{"("}}}); err == nil {
+		t.Error("Expected an invalid pattern to be rejected")
+	}
+	if _, err := newScopeRules(crawlRequest{URL: "http://docs.example.com/", Scope: crawlScope{MaxPages: -1}}); err == nil {
+		t.Error("Expected a negative limit to be rejected")
+	}
+}
+
+func TestCrawlScope(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			http.NotFound(w, r)
+		case "/":
+			fmt.Fprint(w, "<html><head><title>Home</title></head><body><a href=\"/docs/a\">a</a> <a href=\"/docs/b\">b</a> "+
+				"<a href=\"/blog/x\">x</a> <a href=\"http://other.test/\">other</a> <a href=\"/docs/c\">c</a> <a href=\"/docs/d\">d</a></body></html>")
+		default:
+			fmt.Fprint(w, "<html><head><title>"+r.URL.Path+"</title></head><body><p>page</p></body></html>")
+		}
+	}))
+	defer server.Close()
+
+	configuration.MaxDepth = 2
+	configuration.MaxParallel = 1
+	clearIndex()
+	defer clearIndex()
+	job := newCrawlJob(crawlRequest{URL: server.URL + "/",
+		Scope: crawlScope{SameHost: true, Include: []string{`/docs/`, `/$`}, Exclude: []string{`/docs/b`}, MaxPages: 3}})
+	runCrawlJob(job)
+
+	summary := job.snapshot()
+	expected := map[string]int{skippedExclude: 1, skippedInclude: 1, skippedHost: 1, skippedMaxPages: 1}
+	if summary.PagesFetched != 3 || !reflect.DeepEqual(summary.Skipped, expected) {
+		t.Error("Expected three pages crawled and the rest skipped but received", summary.PagesFetched, summary.Skipped)
+	}
+	for _, path := range []string{"/", "/docs/a", "/docs/c"} {
+		if _, ok := getDocument(documentID(server.URL + path)); !ok {
+			t.Error("Expected", path, "to be indexed")
+		}
+	}
+	events, _ := job.eventsSince(0)
+	skipped := 0
+	for _, event := range events {
+		if event.Type == eventOutOfScope && event.Reason != "" {
+			skipped++
+		}
+	}
+	if skipped != 4 {
+		t.Error("Expected an event for each skipped link but received", events)
+	}
+
+	//The start page is crawled even though only its children match Include
+	clearIndex()
+	job = newCrawlJob(crawlRequest{URL: server.URL + "/", Scope: crawlScope{Include: []string{`/docs/.+`}, Exclude: []string{`/$`}}})
+	runCrawlJob(job)
+	summary = job.snapshot()
+	if summary.PagesFetched != 5 {
+		t.Error("Expected the start page and its four docs children crawled but received", summary.PagesFetched, summary.Skipped)
+	}
+	if _, ok := getDocument(documentID(server.URL + "/docs/d")); !ok {
+		t.Error("Expected the start page's children to be followed")
+	}
+
+	recorder := httptest.NewRecorder()
+	indexPageHandler(recorder, httptest.NewRequest("POST", "/index", bytes.NewBufferString(`{"URL": "http://test.com", "Scope": {"Exclude": ["["]}}`)))
+	if recorder.Code != http.StatusUnprocessableEntity {
+		t.Error("Expected an invalid scope to be rejected but received", recorder.Code)
+	}
+}
@@ -1,34 +1,207 @@
 package main
 
-import "sort"
+import (
+	"container/heap"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+)
+
+const defaultSearchLimit = 10
+const maxSearchLimit = 100
+
+var errInvalidCursor = errors.New("Invalid cursor")
+
+// A page of search results with the number of pages matching in all, how long the search took in
+// milliseconds, a cursor for the next page when there is one and a Did you mean query when there are few
+type searchResponse struct {
+	Results    PairList
+	Total      int
+	Took       int64
+	Next       string `json:",omitempty"`
+	Suggestion string `json:",omitempty"`
+}
+
+// Which results of a search to return: up to limit of them, or all at 0, after skipping offset. With a
+// cursor only results ranked below the one it was made from are counted.
+type searchPage struct {
+	limit  int
+	offset int
+	after  *Pair
+}
+
+type resultPage struct {
+	Results PairList
+	Total   int
+	More    bool
+}
+
+// Where a page of results ended, from which the next page carries on even if results have been added above it
+type searchCursor struct {
+	Score float64
+	Count int
+	Title indexCacheInfo
+}
+
+func encodeCursor(last Pair) string {
+	data, _ := json.Marshal(searchCursor{last.Score, last.Count, last.Title})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (*Pair, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var decoded searchCursor
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, errInvalidCursor
+	}
+	return &Pair{Title: decoded.Title, Count: decoded.Count, Score: decoded.Score}, nil
+}
+
+// Builds the response to a query, with snippets of each result showing where the parsed query matched
+func newSearchResponse(query string, node *queryNode, page resultPage, started time.Time) searchResponse {
+	addSnippets(page.Results, node)
+	response := searchResponse{Results: page.Results, Total: page.Total}
+	if response.Results == nil {
+		response.Results = PairList{}
+	}
+	if page.More {
+		response.Next = encodeCursor(page.Results[len(page.Results)-1])
+	}
+	if page.Total < fewResults {
+		response.Suggestion = spellingSuggestion(query)
+	}
+	response.Took = time.Since(started).Milliseconds()
+	return response
+}
 
 func searchIndexForWord(word string) PairList {
-	if titles, ok := indexCache[word]; ok {
-		pl := make(PairList, len(titles))
-		i := 0
-		for k, v := range titles {
-			pl[i] = Pair{k, v}
-			i++
+	_, page := searchWord(word, searchPage{})
+	return page.Results
+}
+
+// Searches for a single word, returning the query that was run along with its page of results
+func searchWord(word string, page searchPage) (*queryNode, resultPage) {
+	query := analyzedQuery(textAnalyzer, "", word)
+	if query == nil {
+		return nil, resultPage{}
+	}
+	results := searchQueryPage(query, page)
+	//Few pages have the word, so pages with misspellings of it are searched too and ranked below them
+	if results.Total < fewResults && query.Kind == termQuery && autoFuzziness(query.Term) > 0 {
+		query.Fuzzy = autoFuzziness(query.Term)
+		results = searchQueryPage(query, page)
+	}
+	return query, results
+}
+
+// Keeps the best ranked page from each near duplicate cluster and counts the others against it
+func collapseDuplicates(pl PairList) PairList {
+	kept := make(map[string]int)
+	collapsed := pl[:0]
+	for _, pair := range pl {
+		cluster, clustered := nearDuplicates.clusters[pair.Title.URL]
+		if !clustered {
+			collapsed = append(collapsed, pair)
+			continue
+		}
+		i, ok := kept[cluster]
+		if !ok {
+			kept[cluster] = len(collapsed)
+			collapsed = append(collapsed, pair)
+			continue
 		}
-		sort.Sort(sort.Reverse(pl))
+		if rankedBelow(collapsed[i], pair) {
+			pair.Similar = collapsed[i].Similar
+			collapsed[i] = pair
+		}
+		collapsed[i].Similar++
+	}
+	return collapsed
+}
+
+// Returns the requested page of results, best first, and whether there are more after it.
+// Only the results up to the end of the page are put in order.
+func pageResults(pl PairList, page searchPage) (PairList, bool) {
+	candidates := pl
+	if page.after != nil {
+		candidates = pl[:0]
+		for _, pair := range pl {
+			if rankedBelow(pair, *page.after) {
+				candidates = append(candidates, pair)
+			}
+		}
+	}
+	if page.offset >= len(candidates) {
+		return nil, false
+	}
+	if page.limit == 0 || page.offset+page.limit >= len(candidates) {
+		sort.Sort(sort.Reverse(candidates))
+		return candidates[page.offset:], false
+	}
+	top := topResults(candidates, page.offset+page.limit)
+	return top[page.offset:], true
+}
+
+// Holds the best results seen so far with the worst of them on top, to be dropped when a better one comes
+type resultHeap PairList
+
+func (h resultHeap) Len() int            { return len(h) }
+func (h resultHeap) Less(i, j int) bool  { return rankedBelow(h[i], h[j]) }
+func (h resultHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *resultHeap) Push(x interface{}) { *h = append(*h, x.(Pair)) }
+func (h *resultHeap) Pop() interface{} {
+	old := *h
+	pair := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return pair
+}
 
-		return pl
+// The k best results, best first
+func topResults(pl PairList, k int) PairList {
+	top := make(resultHeap, 0, k+1)
+	for _, pair := range pl {
+		if len(top) == k && !rankedBelow(top[0], pair) {
+			continue
+		}
+		heap.Push(&top, pair)
+		if len(top) > k {
+			heap.Pop(&top)
+		}
+	}
+	results := make(PairList, len(top))
+	for i := len(results) - 1; i >= 0; i-- {
+		results[i] = heap.Pop(&top).(Pair)
 	}
-	return nil
+	return results
 }
 
 type Pair struct {
-	Title indexCacheInfo
-	Count int
+	Title    indexCacheInfo
+	Count    int
+	Score    float64
+	Similar  int      `json:",omitempty"`
+	Snippets []string `json:",omitempty"`
 }
 type PairList []Pair
 
-func (p PairList) Len() int { return len(p) }
-func (p PairList) Less(i, j int) bool {
-	if p[i].Count == p[j].Count {
-		return p[i].Title.Title < p[j].Title.Title
-	} else {
-		return p[i].Count < p[j].Count
+// Whether a ranks below b: a lower score, then fewer matches. Ties are broken by title and URL so every
+// result has one place in the order for cursors to continue from.
+func rankedBelow(a Pair, b Pair) bool {
+	if a.Score != b.Score {
+		return a.Score < b.Score
+	} else if a.Count != b.Count {
+		return a.Count < b.Count
+	} else if a.Title.Title != b.Title.Title {
+		return a.Title.Title < b.Title.Title
 	}
+	return a.Title.URL > b.Title.URL
 }
-func (p PairList) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+func (p PairList) Len() int           { return len(p) }
+func (p PairList) Less(i, j int) bool { return rankedBelow(p[i], p[j]) }
+func (p PairList) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
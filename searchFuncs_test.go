@@ -1,7 +1,13 @@
 package main
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -14,22 +20,116 @@ func TestSearchIndexForWord(t *testing.T) {
 		cache  map[string]map[indexCacheInfo]int
 		result PairList
 	}{
-		{"a", testCache, []Pair{{indexCacheInfo{"Test Title 4", "test.com"}, 3},
-			{indexCacheInfo{"Test Title 1", "test.com"}, 2},
-			{indexCacheInfo{"Test Title 5", "test.com"}, 1},
-			{indexCacheInfo{"Test Title 2", "test.com"}, 1}}},
+		{"a", testCache, []Pair{{Title: indexCacheInfo{"Test Title 4", "test.com"}, Count: 3},
+			{Title: indexCacheInfo{"Test Title 1", "test.com"}, Count: 2},
+			{Title: indexCacheInfo{"Test Title 5", "test.com"}, Count: 1},
+			{Title: indexCacheInfo{"Test Title 2", "test.com"}, Count: 1}}},
 		{"", testCache, nil},
 		{"d", testCache, nil},
 		{"a", nil, nil},
-		{"b", testCache, []Pair{{indexCacheInfo{"Test Title 2", "test.com"}, 1},
-			{indexCacheInfo{"Test Title 1", "test.com"}, 1}}},
+		{"b", testCache, []Pair{{Title: indexCacheInfo{"Test Title 2", "test.com"}, Count: 1},
+			{Title: indexCacheInfo{"Test Title 1", "test.com"}, Count: 1}}},
 	}
 
 	for _, fixture := range fixtures {
 		indexCache = fixture.cache
-		testResult := searchIndexForWord(fixture.word)
+		testResult := withoutScores(t, searchIndexForWord(fixture.word))
 		if !reflect.DeepEqual(testResult, fixture.result) {
 			t.Error("Result: ", testResult, "does not match expected", fixture.result)
 		}
 	}
 }
+
+// Checks results come back best first, then drops the scores so fixtures can be written as counts
+func withoutScores(t *testing.T, results PairList) PairList {
+	for i := 1; i < len(results); i++ {
+		if results[i].Score > results[i-1].Score {
+			t.Error("Expected results to be ordered by score but found", results)
+		}
+	}
+	for i := range results {
+		results[i].Score = 0
+	}
+	return results
+}
+
+func TestTopResults(t *testing.T) {
+	var pl PairList
+	for i := 0; i < 50; i++ {
+		pl = append(pl, Pair{Title: indexCacheInfo{"Page " + strconv.Itoa(i%7), "test.com/" + strconv.Itoa(i)}, Count: i % 3, Score: float64(i % 11)})
+	}
+	sorted := append(PairList{}, pl...)
+	sort.Sort(sort.Reverse(sorted))
+	for _, k := range []int{1, 5, 49} {
+		if top := topResults(append(PairList{}, pl...), k); !reflect.DeepEqual(top, sorted[:k]) {
+			t.Errorf("Expected the top %d to be %v but received %v", k, sorted[:k], top)
+		}
+	}
+
+	fixtures := []struct {
+		page     searchPage
+		expected PairList
+		more     bool
+	}{
+		{searchPage{}, sorted, false},
+		{searchPage{limit: 10}, sorted[:10], true},
+		{searchPage{limit: 10, offset: 45}, sorted[45:], false},
+		{searchPage{limit: 10, offset: 40}, sorted[40:], false},
+		{searchPage{limit: 10, offset: 50}, nil, false},
+		{searchPage{limit: 5, after: &sorted[9]}, sorted[10:15], true},
+		{searchPage{limit: 5, offset: 2, after: &sorted[9]}, sorted[12:17], true},
+	}
+	for _, fixture := range fixtures {
+		results, more := pageResults(append(PairList{}, pl...), fixture.page)
+		if !reflect.DeepEqual(results, fixture.expected) || more != fixture.more {
+			t.Errorf("Expected page %+v to be %v, %v but received %v, %v", fixture.page, fixture.expected, fixture.more, results, more)
+		}
+	}
+}
+
+func TestSearchPagination(t *testing.T) {
+	clearIndex()
+	defer clearIndex()
+	for i := 0; i < 25; i++ {
+		indexTestDocument(indexCacheInfo{"Go " + strconv.Itoa(i), "test.com/" + strconv.Itoa(i)}, strings.Repeat("golang ", i%4+1)+"tutorial")
+	}
+
+	search := func(target string) (int, searchResponse) {
+		recorder := httptest.NewRecorder()
+		searchHandler(recorder, httptest.NewRequest("GET", target, nil))
+		var response searchResponse
+		json.NewDecoder(recorder.Body).Decode(&response)
+		return recorder.Code, response
+	}
+	_, all := search("/search?q=golang&limit=100")
+	if len(all.Results) != 25 || all.Total != 25 || all.Next != "" {
+		t.Fatal("Expected every result on one page but received", all)
+	}
+	if _, first := search("/search?q=golang"); len(first.Results) != defaultSearchLimit || first.Total != 25 || first.Next == "" {
+		t.Error("Expected the first page to be limited with a cursor for the next but received", first)
+	}
+	if _, page := search("/search?q=golang&limit=5&offset=20"); !reflect.DeepEqual(page.Results, all.Results[20:]) || page.Next != "" {
+		t.Error("Expected the last page by offset but received", page)
+	}
+
+	//Following cursors visits every result once, in order
+	var walked PairList
+	target := "/search?q=golang&limit=7"
+	for pages := 0; pages < 10; pages++ {
+		_, page := search(target)
+		walked = append(walked, page.Results...)
+		if page.Next == "" {
+			break
+		}
+		target = "/search?q=golang&limit=7&cursor=" + page.Next
+	}
+	if !reflect.DeepEqual(walked, all.Results) {
+		t.Error("Expected the cursors to walk through", all.Results, "but received", walked)
+	}
+
+	for _, target := range []string{"/search?q=golang&limit=0", "/search?q=golang&limit=101", "/search?q=golang&offset=-1", "/search?q=golang&cursor=bogus"} {
+		if code, _ := search(target); code != http.StatusUnprocessableEntity {
+			t.Error("Expected", target, "to be rejected but received", code)
+		}
+	}
+}
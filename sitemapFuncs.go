@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Limits from the sitemaps.org protocol. The URL limit holds for everything a crawl reads from sitemaps,
+// so an index cannot multiply it by listing many sitemaps.
+const maxSitemapURLs = 50000
+const maxSitemapSize = 50 * 1024 * 1024
+
+// A sitemap index may point at sitemaps, but those may not point at further indexes
+const maxSitemapNesting = 1
+
+type sitemapURL struct {
+	Loc     string
+	LastMod time.Time
+}
+
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// Decodes both <urlset> and <sitemapindex> documents
+type sitemapDocument struct {
+	XMLName  xml.Name
+	URLs     []sitemapEntry `xml:"url"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+var lastModFormats = []string{time.RFC3339, "2006-01-02T15:04Z07:00", "2006-01-02"}
+
+func parseLastMod(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, format := range lastModFormats {
+		if parsed, err := time.Parse(format, value); err == nil {
+			return parsed
+		}
+	}
+	return time.Time{}
+}
+
+// Parses a sitemap, transparently decompressing it when it starts with the gzip magic bytes
+func parseSitemap(body io.Reader) (sitemapDocument, error) {
+	var document sitemapDocument
+	reader := bufio.NewReader(io.LimitReader(body, maxSitemapSize))
+	if magic, err := reader.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		unzipped, err := gzip.NewReader(reader)
+		if err != nil {
+			return document, err
+		}
+		defer unzipped.Close()
+		reader = bufio.NewReader(io.LimitReader(unzipped, maxSitemapSize))
+	}
+
+	if err := xml.NewDecoder(reader).Decode(&document); err != nil {
+		return document, err
+	}
+	if document.XMLName.Local != "urlset" && document.XMLName.Local != "sitemapindex" {
+		return document, fmt.Errorf("Unexpected sitemap root element <%s>", document.XMLName.Local)
+	}
+	return document, nil
+}
+
+func fetchSitemap(ctx context.Context, URL string) (sitemapDocument, error) {
+	req, err := newCrawlRequest(ctx, URL)
+	if err != nil {
+		return sitemapDocument{}, err
+	}
+	resp, err := crawlClient.Do(req)
+	if err != nil {
+		return sitemapDocument{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return sitemapDocument{}, fmt.Errorf("Sitemap %s returned status %d", URL, resp.StatusCode)
+	}
+	return parseSitemap(resp.Body)
+}
+
+// Collects up to limit page URLs listed in a sitemap, following a sitemap index into its child sitemaps
+func collectSitemap(ctx context.Context, job *crawlJob, URL string, nesting int, seen map[string]bool, limit int) []sitemapURL {
+	if seen[URL] || ctx.Err() != nil || limit <= 0 {
+		return nil
+	}
+	seen[URL] = true
+
+	document, err := fetchSitemap(ctx, URL)
+	if err != nil {
+		if ctx.Err() == nil {
+			fmt.Println("Error reading sitemap", URL, err)
+			job.recordError(err)
+			job.emit(crawlEvent{Type: eventFetchError, URL: URL, Error: err.Error()})
+		}
+		return nil
+	}
+
+	var URLs []sitemapURL
+	for _, entry := range document.URLs {
+		if len(URLs) >= limit {
+			break
+		}
+		if loc := strings.TrimSpace(entry.Loc); loc != "" {
+			URLs = append(URLs, sitemapURL{loc, parseLastMod(entry.LastMod)})
+		}
+	}
+	job.emit(crawlEvent{Type: eventSitemapFetched, URL: URL, Links: len(URLs) + len(document.Sitemaps)})
+
+	if nesting < maxSitemapNesting {
+		for _, child := range document.Sitemaps {
+			if len(URLs) >= limit {
+				break
+			}
+			URLs = append(URLs, collectSitemap(ctx, job, strings.TrimSpace(child.Loc), nesting+1, seen, limit-len(URLs))...)
+		}
+	}
+	return URLs
+}
+
+// Finds the sitemaps to seed a crawl from: the one requested for the job plus any the start host lists in robots.txt
+func loadSitemaps(ctx context.Context, job *crawlJob, startURI string) []sitemapURL {
+	var sitemaps []string
+	if job.Sitemap != "" {
+		sitemaps = append(sitemaps, job.Sitemap)
+	}
+	if startURI != "" && configuration.FollowSitemaps {
+		if robots, err := getRobots(ctx, startURI); err == nil {
+			sitemaps = append(sitemaps, robots.Sitemaps...)
+		}
+	}
+
+	var URLs []sitemapURL
+	seen := make(map[string]bool)
+	for _, sitemap := range sitemaps {
+		URLs = append(URLs, collectSitemap(ctx, job, sitemap, 0, seen, maxSitemapURLs-len(URLs))...)
+	}
+	return URLs
+}
+
+// Recently modified pages are fetched first; pages without a lastmod queue alongside ordinary links
+func sitemapPriority(lastMod time.Time) int64 {
+	if lastMod.IsZero() {
+		return 0
+	}
+	return lastMod.Unix()
+}
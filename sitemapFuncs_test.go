@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testURLSet = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc>%[1]s/old</loc><lastmod>2019-01-02</lastmod></url>
+	<url><loc>%[1]s/new</loc><lastmod>2021-06-01T10:00:00+00:00</lastmod></url>
+	<url><loc> %[1]s/undated </loc></url>
+</urlset>`
+
+func gzipBytes(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+	return buf.Bytes()
+}
+
+func TestParseLastMod(t *testing.T) {
+	fixtures := []struct {
+		value  string
+		result time.Time
+	}{
+		{"2019-01-02", time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"2021-06-01T10:00:00+00:00", time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)},
+		{"2021-06-01T10:00Z", time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)},
+		{"2021-06-01T10:00:00.5Z", time.Date(2021, 6, 1, 10, 0, 0, 500000000, time.UTC)},
+		{"yesterday", time.Time{}},
+		{"", time.Time{}},
+	}
+	for _, fixture := range fixtures {
+		if parsed := parseLastMod(fixture.value); !parsed.Equal(fixture.result) {
+			t.Errorf("Expected %s to parse as %s but received %s", fixture.value, fixture.result, parsed)
+		}
+	}
+}
+
+func TestParseSitemap(t *testing.T) {
+	urlset := fmt.Sprintf(testURLSet, "http://test.com")
+	index := `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"><sitemap><loc>http://test.com/a.xml.gz</loc></sitemap></sitemapindex>`
+
+	fixtures := []struct {
+		body     []byte
+		URLs     int
+		sitemaps int
+		err      bool
+	}{
+		{[]byte(urlset), 3, 0, false},
+		{gzipBytes(t, urlset), 3, 0, false},
+		{[]byte(index), 0, 1, false},
+		{[]byte("<html><body>Not a sitemap</body></html>"), 0, 0, true},
+		{[]byte("not xml"), 0, 0, true},
+	}
+	for _, fixture := range fixtures {
+		document, err := parseSitemap(bytes.NewReader(fixture.body))
+		if (err != nil) != fixture.err {
+			t.Error("Unexpected error", err)
+		}
+		if len(document.URLs) != fixture.URLs || len(document.Sitemaps) != fixture.sitemaps {
+			t.Errorf("Expected %d URLs and %d sitemaps but received %d and %d", fixture.URLs, fixture.sitemaps, len(document.URLs), len(document.Sitemaps))
+		}
+	}
+}
+
+func TestCrawlFromSitemap(t *testing.T) {
+	var fetched []string
+	var fetchedMutex sync.Mutex
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprintf(w, "User-Agent: *\nSitemap: %s/sitemap_index.xml", server.URL)
+		case "/sitemap_index.xml":
+			fmt.Fprintf(w, "<sitemapindex><sitemap><loc>%s/pages.xml.gz</loc></sitemap></sitemapindex>", server.URL)
+		case "/pages.xml.gz":
+			w.Write(gzipBytes(t, fmt.Sprintf(testURLSet, server.URL)))
+		default:
+			fetchedMutex.Lock()
+			fetched = append(fetched, r.URL.Path)
+			fetchedMutex.Unlock()
+			fmt.Fprintf(w, "<head><Title>%s</Title></head>", strings.Trim(r.URL.Path, "/"))
+		}
+	}))
+	defer server.Close()
+
+	configuration.MaxDepth = 1
+	configuration.MaxParallel = 1
+	configuration.FollowSitemaps = true
+	defer func() { configuration.FollowSitemaps = false }()
+	clearRobots()
+
+	fixtures := []struct {
+		request  crawlRequest
+		expected []string
+	}{
+		{crawlRequest{URL: server.URL + "/start"}, []string{"/start", "/new", "/old", "/undated"}},
+		{crawlRequest{Sitemap: server.URL + "/pages.xml.gz"}, []string{"/new", "/old", "/undated"}},
+	}
+	for _, fixture := range fixtures {
+		fetched = nil
+		indexCache = map[string]map[indexCacheInfo]int{}
+		job := newCrawlJob(fixture.request)
+		runCrawlJob(job)
+
+		//Only the sitemap pages are ordered by lastmod; the start page races the sitemap download
+		var sitemapPages []string
+		for _, page := range fetched {
+			if page != "/start" {
+				sitemapPages = append(sitemapPages, page)
+			}
+		}
+		if len(sitemapPages) != len(fetched) {
+			sitemapPages = append([]string{"/start"}, sitemapPages...)
+		}
+		if !reflect.DeepEqual(sitemapPages, fixture.expected) {
+			t.Error("Expected pages to be fetched in order", fixture.expected, "but received", fetched)
+		}
+	}
+}
+
+func TestSitemapURLLimit(t *testing.T) {
+	var fetched []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = append(fetched, r.URL.Path)
+		if r.URL.Path == "/index.xml" {
+			fmt.Fprintf(w, "<sitemapindex><sitemap><loc>%[1]s/a.xml</loc></sitemap><sitemap><loc>%[1]s/b.xml</loc></sitemap>"+
+				"<sitemap><loc>%[1]s/c.xml</loc></sitemap></sitemapindex>", server.URL)
+			return
+		}
+		fmt.Fprint(w, "<urlset>")
+		for i := 0; i < maxSitemapURLs*3/5; i++ {
+			fmt.Fprintf(w, "<url><loc>%s%s/%d</loc></url>", server.URL, r.URL.Path, i)
+		}
+		fmt.Fprint(w, "</urlset>")
+	}))
+	defer server.Close()
+
+	job := &crawlJob{crawlRequest: crawlRequest{Sitemap: server.URL + "/index.xml"}, Status: jobRunning, subscribers: make(map[chan struct{}]bool)}
+	URLs := loadSitemaps(context.Background(), job, "")
+	if len(URLs) != maxSitemapURLs {
+		t.Error("Expected the index and its sitemaps to give", maxSitemapURLs, "URLs between them but received", len(URLs))
+	}
+	if !reflect.DeepEqual(fetched, []string{"/index.xml", "/a.xml", "/b.xml"}) {
+		t.Error("Expected no more sitemaps to be fetched once the limit was reached but received", fetched)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"html"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+const defaultSnippets = 2
+const defaultSnippetWords = 30
+const defaultHighlightPre = "<em>"
+const defaultHighlightPost = "</em>"
+
+// Only the first matches in a long page are considered for snippets
+const maxSnippetCandidates = 200
+
+// The extracted text of each page, compressed and keyed by canonical URL. Guarded by indexCashMutex.
+var documentTexts = map[string][]byte{}
+
+func compressText(text string) []byte {
+	var compressed bytes.Buffer
+	writer, _ := flate.NewWriter(&compressed, flate.DefaultCompression)
+	writer.Write([]byte(text))
+	writer.Close()
+	return compressed.Bytes()
+}
+
+func decompressText(compressed []byte) (string, error) {
+	reader := flate.NewReader(bytes.NewReader(compressed))
+	defer reader.Close()
+	text, err := ioutil.ReadAll(reader)
+	return string(text), err
+}
+
+// Snippets per result, words per snippet and the markers put around matches, from config or their defaults.
+// A negative number of snippets turns them off.
+func snippetSettings() (int, int, string, string) {
+	count, words := configuration.Snippets, configuration.SnippetWords
+	if count == 0 {
+		count = defaultSnippets
+	}
+	if words <= 0 {
+		words = defaultSnippetWords
+	}
+	pre, post := configuration.HighlightPre, configuration.HighlightPost
+	if pre == "" && post == "" {
+		pre, post = defaultHighlightPre, defaultHighlightPost
+	}
+	return count, words, pre, post
+}
+
+// The terms a query can match in page text: its words outside other fields, with every spelling a fuzzy
+// word accepts. Callers must hold indexCashMutex.
+func snippetTerms(query *queryNode) map[string]bool {
+	terms := map[string]bool{}
+	for _, leaf := range query.leaves() {
+		if leaf.Field != "" && leaf.Field != bodyField {
+			continue
+		}
+		terms[leaf.Term] = true
+		if leaf.Fuzzy > 0 {
+			for _, variant := range dictionary.fuzzy(leaf.Term, leaf.Fuzzy, maxFuzzyExpansions) {
+				terms[variant.Term] = true
+			}
+		}
+	}
+	return terms
+}
+
+// A run of tokens shown as a snippet, by index into the page's tokens
+type snippetWindow struct {
+	first    int
+	last     int
+	distinct int
+	matches  int
+}
+
+// Picks up to count windows of the text with the most different terms in them, then the most matches,
+// and returns them in page order with matches wrapped in pre and post. Text between matches is HTML
+// escaped so snippets can be shown as HTML. A page where no term appears gets its opening words.
+func makeSnippets(text string, analyzer Analyzer, terms map[string]bool, count int, words int, pre string, post string) []string {
+	tokens := analyzer.Analyze(text)
+	if len(tokens) == 0 || count <= 0 {
+		return nil
+	}
+	window := func(first int) snippetWindow {
+		candidate := snippetWindow{first: first, last: first}
+		seen := map[string]bool{}
+		for i := first; i < len(tokens) && tokens[i].Position < tokens[first].Position+words; i++ {
+			candidate.last = i
+			if terms[tokens[i].Text] {
+				candidate.matches++
+				if !seen[tokens[i].Text] {
+					seen[tokens[i].Text] = true
+					candidate.distinct++
+				}
+			}
+		}
+		return candidate
+	}
+
+	//Each match is tried a little way into a window so the words leading up to it are shown
+	var candidates []snippetWindow
+	lead := words / 4
+	for i, token := range tokens {
+		if !terms[token.Text] {
+			continue
+		}
+		first := sort.Search(len(tokens), func(j int) bool { return tokens[j].Position >= token.Position-lead })
+		candidates = append(candidates, window(minInt(first, i)))
+		if len(candidates) == maxSnippetCandidates {
+			break
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = append(candidates, window(0))
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].distinct != candidates[j].distinct {
+			return candidates[i].distinct > candidates[j].distinct
+		}
+		return candidates[i].matches > candidates[j].matches
+	})
+
+	var chosen []snippetWindow
+	for _, candidate := range candidates {
+		overlaps := false
+		for _, other := range chosen {
+			if candidate.first <= other.last && other.first <= candidate.last {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			chosen = append(chosen, candidate)
+		}
+		if len(chosen) == count {
+			break
+		}
+	}
+	sort.Slice(chosen, func(i, j int) bool { return chosen[i].first < chosen[j].first })
+
+	snippets := make([]string, len(chosen))
+	for i, window := range chosen {
+		var snippet strings.Builder
+		if window.first > 0 {
+			snippet.WriteString("…")
+		}
+		//A window at either end of the text keeps whatever is before its first word or after its last
+		written, end := tokens[window.first].Start, tokens[window.last].End
+		if window.first == 0 {
+			written = 0
+		}
+		if window.last == len(tokens)-1 {
+			end = len(text)
+		}
+		for _, token := range tokens[window.first : window.last+1] {
+			if !terms[token.Text] {
+				continue
+			}
+			snippet.WriteString(html.EscapeString(text[written:token.Start]))
+			snippet.WriteString(pre + html.EscapeString(text[token.Start:token.End]) + post)
+			written = token.End
+		}
+		snippet.WriteString(html.EscapeString(text[written:end]))
+		if window.last < len(tokens)-1 {
+			snippet.WriteString("…")
+		}
+		snippets[i] = snippet.String()
+	}
+	return snippets
+}
+
+// Adds snippets of each result's stored text showing where the query matched
+func addSnippets(results PairList, query *queryNode) {
+	count, words, pre, post := snippetSettings()
+	if count < 0 || query == nil {
+		return
+	}
+	texts := make([][]byte, len(results))
+	analyzers := make([]Analyzer, len(results))
+	indexCashMutex.RLock()
+	terms := snippetTerms(query)
+	for i, result := range results {
+		texts[i] = documentTexts[result.Title.URL]
+		analyzers[i] = analyzerFor(documentLanguages[result.Title.URL])
+	}
+	indexCashMutex.RUnlock()
+
+	for i := range results {
+		if texts[i] == nil {
+			continue
+		}
+		text, err := decompressText(texts[i])
+		if err != nil {
+			continue
+		}
+		results[i].Snippets = makeSnippets(text, analyzers[i], terms, count, words, pre, post)
+	}
+}
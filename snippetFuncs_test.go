@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompressText(t *testing.T) {
+	text := strings.Repeat("Die alten Häuser am Fluss ", 100)
+	compressed := compressText(text)
+	if len(compressed) >= len(text) {
+		t.Error("Expected repetitive text to compress but received", len(compressed), "bytes")
+	}
+	if decompressed, err := decompressText(compressed); err != nil || decompressed != text {
+		t.Error("Expected the text back but received", decompressed, err)
+	}
+	if _, err := decompressText([]byte("not flate")); err == nil {
+		t.Error("Expected corrupt text to fail to decompress")
+	}
+}
+
+func TestMakeSnippets(t *testing.T) {
+	analyzer := newAnalyzer("standard", nil)
+	numbers := "one two three four five six seven eight nine ten"
+	fixtures := []struct {
+		text     string
+		terms    []string
+		count    int
+		words    int
+		snippets []string
+	}{
+		{"The quick brown fox jumps over the lazy dog", []string{"fox"}, 1, 30, []string{"The quick brown <em>fox</em> jumps over the lazy dog"}},
+		{"Quick FOX, quick fox!", []string{"fox", "quick"}, 1, 30, []string{"<em>Quick</em> <em>FOX</em>, <em>quick</em> <em>fox</em>!"}},
+		{"Use a<b & fox", []string{"fox"}, 1, 30, []string{"Use a&lt;b &amp; <em>fox</em>"}},
+		{numbers, []string{"six"}, 1, 4, []string{"…five <em>six</em> seven eight…"}},
+		{numbers, []string{"two", "nine"}, 2, 4, []string{"one <em>two</em> three four…", "…eight <em>nine</em> ten"}},
+		{numbers, []string{"zebra"}, 2, 3, []string{"one two three…"}},
+		{"alpha x x x x x x x beta gamma x x", []string{"alpha", "beta", "gamma"}, 1, 3, []string{"…<em>beta</em> <em>gamma</em> x…"}},
+		{"", []string{"fox"}, 1, 30, nil},
+	}
+	for _, fixture := range fixtures {
+		terms := map[string]bool{}
+		for _, term := range fixture.terms {
+			terms[term] = true
+		}
+		snippets := makeSnippets(fixture.text, analyzer, terms, fixture.count, fixture.words, "<em>", "</em>")
+		if !reflect.DeepEqual(snippets, fixture.snippets) {
+			t.Errorf("Expected %q with %v to give %q but received %q", fixture.text, fixture.terms, fixture.snippets, snippets)
+		}
+	}
+
+	if snippets := makeSnippets("Go fast", analyzer, map[string]bool{"go": true}, 1, 30, "[", "]"); snippets[0] != "[Go] fast" {
+		t.Error("Expected the configured markers but received", snippets)
+	}
+}
+
+func TestSearchSnippets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			http.NotFound(w, r)
+		default:
+			fmt.Fprint(w, "<html><head><title>Foxes</title></head><body><p>The quick brown fox jumps over the lazy dog</p></body></html>")
+		}
+	}))
+	defer server.Close()
+
+	configuration.MaxDepth = 1
+	configuration.MaxParallel = 1
+	clearIndex()
+	defer clearIndex()
+	runCrawlJob(newCrawlJob(crawlRequest{URL: server.URL + "/"}))
+
+	search := func(target string) searchResponse {
+		recorder := httptest.NewRecorder()
+		searchHandler(recorder, httptest.NewRequest("GET", target, nil))
+		var response searchResponse
+		json.NewDecoder(recorder.Body).Decode(&response)
+		return response
+	}
+	expected := []string{"Foxes The quick brown <em>fox</em> jumps over the lazy <em>dog</em>"}
+	if response := search("/search?q=fox+dog"); len(response.Results) != 1 || !reflect.DeepEqual(response.Results[0].Snippets, expected) {
+		t.Error("Expected a snippet with both words highlighted but received", response)
+	}
+	expected = []string{"Foxes The quick brown <em>fox</em> jumps over the lazy dog"}
+	if response := search("/search?q=title:dog+OR+fox"); len(response.Results) != 1 || !reflect.DeepEqual(response.Results[0].Snippets, expected) {
+		t.Error("Expected words searched for in other fields not to be highlighted but received", response)
+	}
+	query, results := searchWord("foxx", searchPage{})
+	if response := newSearchResponse("foxx", query, results, time.Now()); len(response.Results) != 1 || !reflect.DeepEqual(response.Results[0].Snippets, expected) {
+		t.Error("Expected a misspelled word search to highlight what it matched but received", response)
+	}
+
+	configuration.Snippets = -1
+	defer func() { configuration.Snippets = 0 }()
+	if response := search("/search?q=fox"); len(response.Results) != 1 || response.Results[0].Snippets != nil {
+		t.Error("Expected no snippets when they are turned off but received", response)
+	}
+	configuration.Snippets = 0
+
+	//The text is kept in snapshots
+	dir, err := ioutil.TempDir("", "kgp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := saveSnapshot(dir); err != nil {
+		t.Fatal(err)
+	}
+	indexCashMutex.Lock()
+	documentTexts = map[string][]byte{}
+	indexCashMutex.Unlock()
+	if _, err := loadSnapshot(dir); err != nil {
+		t.Fatal(err)
+	}
+	if response := search("/search?q=fox"); len(response.Results) != 1 || !reflect.DeepEqual(response.Results[0].Snippets, expected) {
+		t.Error("Expected snippets from a restored snapshot but received", response)
+	}
+
+	clearIndex()
+	indexCashMutex.RLock()
+	defer indexCashMutex.RUnlock()
+	if len(documentTexts) != 0 {
+		t.Error("Expected clearing the index to drop page text but found", documentTexts)
+	}
+}
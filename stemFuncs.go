@@ -0,0 +1,459 @@
+package main
+
+import "strings"
+
+// The Porter stemming algorithm (M.F. Porter, 1980) for English, so "running", "runs" and "run" share a term.
+// Only words made of the letters a to z are stemmed; anything else is returned unchanged.
+func porterStem(word string) string {
+	if len(word) <= 2 || !isStemmable(word) {
+		return word
+	}
+	w := []byte(word)
+	w = porterStep1a(w)
+	w = porterStep1b(w)
+	w = porterStep1c(w)
+	w = porterStep2(w)
+	w = porterStep3(w)
+	w = porterStep4(w)
+	w = porterStep5(w)
+	return string(w)
+}
+
+// Stemmers only handle words made of the letters a to z, as folding leaves German and Spanish words
+func isStemmable(word string) bool {
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// A consonant is any letter other than a, e, i, o or u, and other than y preceded by a consonant
+func isConsonant(w []byte, i int) bool {
+	switch w[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !isConsonant(w, i-1)
+	}
+	return true
+}
+
+// The number of vowel-consonant sequences in the stem, written m in the algorithm
+func measure(stem []byte) int {
+	m := 0
+	i := 0
+	for i < len(stem) && isConsonant(stem, i) {
+		i++
+	}
+	for i < len(stem) {
+		for i < len(stem) && !isConsonant(stem, i) {
+			i++
+		}
+		if i >= len(stem) {
+			break
+		}
+		for i < len(stem) && isConsonant(stem, i) {
+			i++
+		}
+		m++
+	}
+	return m
+}
+
+func containsVowel(stem []byte) bool {
+	for i := range stem {
+		if !isConsonant(stem, i) {
+			return true
+		}
+	}
+	return false
+}
+
+func endsDoubleConsonant(w []byte) bool {
+	n := len(w)
+	return n >= 2 && w[n-1] == w[n-2] && isConsonant(w, n-1)
+}
+
+// Consonant-vowel-consonant where the last consonant is not w, x or y, as in hop but not snow
+func endsCVC(w []byte) bool {
+	n := len(w)
+	if n < 3 || !isConsonant(w, n-1) || isConsonant(w, n-2) || !isConsonant(w, n-3) {
+		return false
+	}
+	return w[n-1] != 'w' && w[n-1] != 'x' && w[n-1] != 'y'
+}
+
+func hasSuffix(w []byte, suffix string) bool {
+	return strings.HasSuffix(string(w), suffix)
+}
+
+// Replaces the first suffix in rules the word ends with, if what remains has a measure above minMeasure.
+// Returns whether any suffix matched, even if the measure kept it from being replaced.
+func replaceSuffix(w []byte, rules [][2]string, minMeasure int) ([]byte, bool) {
+	for _, rule := range rules {
+		if hasSuffix(w, rule[0]) {
+			stem := w[:len(w)-len(rule[0])]
+			if measure(stem) > minMeasure {
+				return append(stem[:len(stem):len(stem)], rule[1]...), true
+			}
+			return w, true
+		}
+	}
+	return w, false
+}
+
+func porterStep1a(w []byte) []byte {
+	switch {
+	case hasSuffix(w, "sses"), hasSuffix(w, "ies"):
+		return w[:len(w)-2]
+	case hasSuffix(w, "ss"):
+		return w
+	case hasSuffix(w, "s"):
+		return w[:len(w)-1]
+	}
+	return w
+}
+
+func porterStep1b(w []byte) []byte {
+	if hasSuffix(w, "eed") {
+		if measure(w[:len(w)-3]) > 0 {
+			return w[:len(w)-1]
+		}
+		return w
+	}
+	var stem []byte
+	switch {
+	case hasSuffix(w, "ed") && containsVowel(w[:len(w)-2]):
+		stem = w[:len(w)-2]
+	case hasSuffix(w, "ing") && containsVowel(w[:len(w)-3]):
+		stem = w[:len(w)-3]
+	default:
+		return w
+	}
+	stem = stem[:len(stem):len(stem)]
+	switch {
+	case hasSuffix(stem, "at"), hasSuffix(stem, "bl"), hasSuffix(stem, "iz"):
+		return append(stem, 'e')
+	case endsDoubleConsonant(stem):
+		if last := stem[len(stem)-1]; last != 'l' && last != 's' && last != 'z' {
+			return stem[:len(stem)-1]
+		}
+	case measure(stem) == 1 && endsCVC(stem):
+		return append(stem, 'e')
+	}
+	return stem
+}
+
+func porterStep1c(w []byte) []byte {
+	if hasSuffix(w, "y") && containsVowel(w[:len(w)-1]) {
+		stem := w[: len(w)-1 : len(w)-1]
+		return append(stem, 'i')
+	}
+	return w
+}
+
+var porterStep2Rules = [][2]string{
+	{"ational", "ate"}, {"tional", "tion"}, {"enci", "ence"}, {"anci", "ance"}, {"izer", "ize"},
+	{"abli", "able"}, {"alli", "al"}, {"entli", "ent"}, {"eli", "e"}, {"ousli", "ous"},
+	{"ization", "ize"}, {"ation", "ate"}, {"ator", "ate"}, {"alism", "al"}, {"iveness", "ive"},
+	{"fulness", "ful"}, {"ousness", "ous"}, {"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"},
+}
+
+func porterStep2(w []byte) []byte {
+	w, _ = replaceSuffix(w, porterStep2Rules, 0)
+	return w
+}
+
+var porterStep3Rules = [][2]string{
+	{"icate", "ic"}, {"ative", ""}, {"alize", "al"}, {"iciti", "ic"}, {"ical", "ic"}, {"ful", ""}, {"ness", ""},
+}
+
+func porterStep3(w []byte) []byte {
+	w, _ = replaceSuffix(w, porterStep3Rules, 0)
+	return w
+}
+
+var porterStep4Suffixes = []string{
+	"al", "ance", "ence", "er", "ic", "able", "ible", "ant", "ement", "ment", "ent",
+	"ion", "ou", "ism", "ate", "iti", "ous", "ive", "ize",
+}
+
+func porterStep4(w []byte) []byte {
+	//Longest suffix first, as ement must win over ment and ent
+	best := ""
+	for _, suffix := range porterStep4Suffixes {
+		if hasSuffix(w, suffix) && len(suffix) > len(best) {
+			best = suffix
+		}
+	}
+	if best == "" {
+		return w
+	}
+	stem := w[:len(w)-len(best)]
+	if measure(stem) <= 1 {
+		return w
+	}
+	if best == "ion" && (len(stem) == 0 || (stem[len(stem)-1] != 's' && stem[len(stem)-1] != 't')) {
+		return w
+	}
+	return stem
+}
+
+func porterStep5(w []byte) []byte {
+	if hasSuffix(w, "e") {
+		stem := w[:len(w)-1]
+		if m := measure(stem); m > 1 || (m == 1 && !endsCVC(stem)) {
+			w = stem
+		}
+	}
+	if measure(w) > 1 && endsDoubleConsonant(w) && w[len(w)-1] == 'l' {
+		w = w[:len(w)-1]
+	}
+	return w
+}
+
+// The start of R1 and R2 as Snowball defines them: the region after the first non-vowel following a vowel,
+// and the same region again within R1. Either is the end of the word when there is no such letter.
+func snowballRegions(w []byte, isVowel func(byte) bool) (int, int) {
+	region := func(start int) int {
+		for i := start + 1; i < len(w); i++ {
+			if !isVowel(w[i]) && isVowel(w[i-1]) {
+				return i + 1
+			}
+		}
+		return len(w)
+	}
+	r1 := region(0)
+	return r1, region(r1)
+}
+
+// The longest of the suffixes the word ends with, or "" if none
+func longestSuffix(w []byte, suffixes ...string) string {
+	longest := ""
+	for _, suffix := range suffixes {
+		if len(suffix) > len(longest) && hasSuffix(w, suffix) {
+			longest = suffix
+		}
+	}
+	return longest
+}
+
+func isGermanVowel(c byte) bool {
+	return strings.IndexByte("aeiouy", c) >= 0
+}
+
+// The Snowball German stemmer. It runs on folded words, so umlauts have already become plain vowels
+// and ß is ss, as the algorithm would leave them.
+func germanStem(word string) string {
+	if !isStemmable(word) {
+		return word
+	}
+	w := []byte(word)
+	//u and y between vowels act as consonants, which upper case marks until the end
+	for i := 1; i+1 < len(w); i++ {
+		if (w[i] == 'u' || w[i] == 'y') && isGermanVowel(w[i-1]) && isGermanVowel(w[i+1]) {
+			w[i] -= 'a' - 'A'
+		}
+	}
+	r1, r2 := snowballRegions(w, isGermanVowel)
+	if r1 < 3 {
+		r1 = 3
+	}
+
+	switch suffix := longestSuffix(w, "em", "ern", "er", "e", "en", "es", "s"); suffix {
+	case "em", "ern", "er":
+		if len(w)-len(suffix) >= r1 {
+			w = w[:len(w)-len(suffix)]
+		}
+	case "e", "en", "es":
+		if len(w)-len(suffix) >= r1 {
+			w = w[:len(w)-len(suffix)]
+			if hasSuffix(w, "niss") {
+				w = w[:len(w)-1]
+			}
+		}
+	case "s":
+		if len(w)-1 >= r1 && len(w) >= 2 && strings.IndexByte("bdfghklmnrt", w[len(w)-2]) >= 0 {
+			w = w[:len(w)-1]
+		}
+	}
+
+	switch suffix := longestSuffix(w, "en", "er", "est", "st"); suffix {
+	case "en", "er", "est":
+		if len(w)-len(suffix) >= r1 {
+			w = w[:len(w)-len(suffix)]
+		}
+	case "st":
+		if len(w)-2 >= r1 && len(w) >= 6 && strings.IndexByte("bdfghklmnt", w[len(w)-3]) >= 0 {
+			w = w[:len(w)-2]
+		}
+	}
+
+	inR1 := func(suffix string) bool { return hasSuffix(w, suffix) && len(w)-len(suffix) >= r1 }
+	inR2 := func(suffix string) bool { return hasSuffix(w, suffix) && len(w)-len(suffix) >= r2 }
+	switch suffix := longestSuffix(w, "end", "ung", "ig", "ik", "isch", "lich", "heit", "keit"); suffix {
+	case "end", "ung":
+		if inR2(suffix) {
+			w = w[:len(w)-len(suffix)]
+			if inR2("ig") && !hasSuffix(w, "eig") {
+				w = w[:len(w)-2]
+			}
+		}
+	case "ig", "ik", "isch":
+		if inR2(suffix) && !hasSuffix(w, "e"+suffix) {
+			w = w[:len(w)-len(suffix)]
+		}
+	case "lich", "heit":
+		if inR2(suffix) {
+			w = w[:len(w)-len(suffix)]
+			if inR1("er") || inR1("en") {
+				w = w[:len(w)-2]
+			}
+		}
+	case "keit":
+		if inR2(suffix) {
+			w = w[:len(w)-len(suffix)]
+			if inR2("lich") {
+				w = w[:len(w)-4]
+			} else if inR2("ig") {
+				w = w[:len(w)-2]
+			}
+		}
+	}
+	return strings.ToLower(string(w))
+}
+
+func isSpanishVowel(c byte) bool {
+	return strings.IndexByte("aeiou", c) >= 0
+}
+
+// Suffixes of the Snowball Spanish stemmer, written without the accents folding has already removed
+var spanishPronouns = []string{"me", "se", "sela", "selo", "selas", "selos", "la", "le", "lo", "las", "les", "los", "nos"}
+var spanishSuffixes = []string{
+	"anza", "anzas", "ico", "ica", "icos", "icas", "ismo", "ismos", "able", "ables", "ible", "ibles", "ista", "istas",
+	"oso", "osa", "osos", "osas", "amiento", "amientos", "imiento", "imientos",
+	"adora", "ador", "acion", "adoras", "adores", "aciones", "ante", "antes", "ancia", "ancias",
+	"logia", "logias", "ucion", "uciones", "encia", "encias", "amente", "mente", "idad", "idades", "iva", "ivo", "ivas", "ivos",
+}
+var spanishYVerbSuffixes = []string{"ya", "ye", "yan", "yen", "yeron", "yendo", "yo", "yas", "yes", "yais", "yamos"}
+var spanishVerbSuffixes = []string{
+	"en", "es", "eis", "emos",
+	"arian", "arias", "aran", "aras", "ariais", "aria", "areis", "ariamos", "aremos", "ara", "are",
+	"erian", "erias", "eran", "eras", "eriais", "eria", "ereis", "eriamos", "eremos", "era", "ere",
+	"irian", "irias", "iran", "iras", "iriais", "iria", "ireis", "iriamos", "iremos", "ira", "ire",
+	"aba", "ada", "ida", "ia", "iera", "ad", "ed", "id", "ase", "iese", "aste", "iste", "an", "aban", "ian", "ieran",
+	"asen", "iesen", "aron", "ieron", "ado", "ido", "ando", "iendo", "io", "ar", "er", "ir", "as", "abas", "adas", "idas",
+	"ias", "ieras", "ases", "ieses", "is", "ais", "abais", "iais", "arais", "ierais", "aseis", "ieseis", "asteis", "isteis",
+	"ados", "idos", "amos", "abamos", "iamos", "imos", "aramos", "ieramos", "iesemos", "asemos",
+}
+
+// The Snowball Spanish stemmer, run on folded words
+func spanishStem(word string) string {
+	if !isStemmable(word) {
+		return word
+	}
+	w := []byte(word)
+	r1, r2 := snowballRegions(w, isSpanishVowel)
+	//RV follows the first vowel after a leading consonant pair, the first consonant after a leading vowel
+	//pair, or the third letter otherwise
+	rv := len(w)
+	if len(w) >= 2 {
+		next := func(vowel bool) int {
+			for i := 2; i < len(w); i++ {
+				if isSpanishVowel(w[i]) == vowel {
+					return i + 1
+				}
+			}
+			return len(w)
+		}
+		switch {
+		case !isSpanishVowel(w[1]):
+			rv = next(true)
+		case isSpanishVowel(w[0]):
+			rv = next(false)
+		default:
+			rv = minInt(3, len(w))
+		}
+	}
+	in := func(region int, suffix string) bool { return hasSuffix(w, suffix) && len(w)-len(suffix) >= region }
+	trim := func(suffix string) { w = w[:len(w)-len(suffix)] }
+
+	//Pronouns attached to a gerund or infinitive, as in bebiendolo
+	if pronoun := longestSuffix(w, spanishPronouns...); pronoun != "" {
+		stem := w[:len(w)-len(pronoun)]
+		switch ending := longestSuffix(stem, "iendo", "ando", "ar", "er", "ir", "yendo"); {
+		case ending == "yendo" && len(stem)-len(ending) >= rv && hasSuffix(stem, "uyendo"):
+			w = stem
+		case ending != "" && ending != "yendo" && len(stem)-len(ending) >= rv:
+			w = stem
+		}
+	}
+
+	removed := false
+	switch suffix := longestSuffix(w, spanishSuffixes...); {
+	case suffix == "amente":
+		if !in(r1, suffix) {
+			break
+		}
+		removed = true
+		trim(suffix)
+		if in(r2, "iv") {
+			trim("iv")
+			if in(r2, "at") {
+				trim("at")
+			}
+		} else if preceding := longestSuffix(w, "os", "ic", "ad"); preceding != "" && in(r2, preceding) {
+			trim(preceding)
+		}
+	case suffix != "" && in(r2, suffix):
+		removed = true
+		trim(suffix)
+		switch suffix {
+		case "logia", "logias":
+			w = append(w[:len(w):len(w)], "log"...)
+		case "ucion", "uciones":
+			w = append(w[:len(w):len(w)], 'u')
+		case "encia", "encias":
+			w = append(w[:len(w):len(w)], "ente"...)
+		case "mente":
+			if preceding := longestSuffix(w, "ante", "able", "ible"); preceding != "" && in(r2, preceding) {
+				trim(preceding)
+			}
+		case "idad", "idades":
+			if preceding := longestSuffix(w, "abil", "ic", "iv"); preceding != "" && in(r2, preceding) {
+				trim(preceding)
+			}
+		case "iva", "ivo", "ivas", "ivos":
+			if in(r2, "at") {
+				trim("at")
+			}
+		case "adora", "ador", "acion", "adoras", "adores", "aciones", "ante", "antes", "ancia", "ancias":
+			if in(r2, "ic") {
+				trim("ic")
+			}
+		}
+	}
+
+	if !removed {
+		if suffix := longestSuffix(w, spanishYVerbSuffixes...); suffix != "" && in(rv, suffix) && hasSuffix(w, "u"+suffix) {
+			trim(suffix)
+		} else if suffix := longestSuffix(w, spanishVerbSuffixes...); suffix != "" && in(rv, suffix) {
+			trim(suffix)
+			if (suffix == "en" || suffix == "es" || suffix == "eis" || suffix == "emos") && hasSuffix(w, "gu") {
+				w = w[:len(w)-1]
+			}
+		}
+	}
+
+	if suffix := longestSuffix(w, "os", "a", "o"); suffix != "" && in(rv, suffix) {
+		trim(suffix)
+	} else if in(rv, "e") {
+		trim("e")
+		if in(rv, "u") && hasSuffix(w, "gu") {
+			w = w[:len(w)-1]
+		}
+	}
+	return string(w)
+}
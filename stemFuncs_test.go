@@ -0,0 +1,80 @@
+package main
+
+import "testing"
+
+func TestPorterStem(t *testing.T) {
+	fixtures := []struct {
+		word string
+		stem string
+	}{
+		{"caresses", "caress"},
+		{"ponies", "poni"},
+		{"cats", "cat"},
+		{"agreed", "agre"},
+		{"plastered", "plaster"},
+		{"motoring", "motor"},
+		{"sing", "sing"},
+		{"hopping", "hop"},
+		{"falling", "fall"},
+		{"filing", "file"},
+		{"happy", "happi"},
+		{"relational", "relat"},
+		{"rational", "ration"},
+		{"conditional", "condit"},
+		{"generalization", "gener"},
+		{"running", "run"},
+		{"runs", "run"},
+		{"connections", "connect"},
+		{"go", "go"},
+		{"x86", "x86"},
+		{"naïve", "naïve"},
+	}
+	for _, fixture := range fixtures {
+		if stem := porterStem(fixture.word); stem != fixture.stem {
+			t.Errorf("Expected %s to stem to %s but received %s", fixture.word, fixture.stem, stem)
+		}
+	}
+}
+
+func TestGermanStem(t *testing.T) {
+	fixtures := []struct {
+		word string
+		stem string
+	}{
+		{"hauser", "haus"},
+		{"laufen", "lauf"},
+		{"katzen", "katz"},
+		{"bucher", "buch"},
+		{"kategorien", "kategori"},
+		{"freundlichkeit", "freundlich"},
+		{"ergebnisse", "ergebnis"},
+		{"bauer", "bau"},
+		{"go", "go"},
+	}
+	for _, fixture := range fixtures {
+		if stem := germanStem(fixture.word); stem != fixture.stem {
+			t.Errorf("Expected %s to stem to %s but received %s", fixture.word, fixture.stem, stem)
+		}
+	}
+}
+
+func TestSpanishStem(t *testing.T) {
+	fixtures := []struct {
+		word string
+		stem string
+	}{
+		{"canciones", "cancion"},
+		{"rapidamente", "rapid"},
+		{"chicas", "chic"},
+		{"corriendo", "corr"},
+		{"ciudades", "ciudad"},
+		{"bebiendolo", "beb"},
+		{"nacionalidad", "nacional"},
+		{"go", "go"},
+	}
+	for _, fixture := range fixtures {
+		if stem := spanishStem(fixture.word); stem != fixture.stem {
+			t.Errorf("Expected %s to stem to %s but received %s", fixture.word, fixture.stem, stem)
+		}
+	}
+}
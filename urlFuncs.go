@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+)
+
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// Reduces the many spellings of a page's address to one so they share a seen entry and a document.
+// Hosts are lowercased, default ports, fragments and tracking parameters dropped, the query sorted
+// and a trailing slash removed from any path other than the root.
+func canonicalURL(link string) (string, error) {
+	parsed, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return "", err
+	}
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return "", errors.New("Cannot Canonicalize Relative URL")
+	}
+
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	host := strings.ToLower(parsed.Host)
+	if hostname, port, err := net.SplitHostPort(host); err == nil && defaultPorts[parsed.Scheme] == port {
+		host = hostname
+		if strings.Contains(hostname, ":") {
+			host = "[" + hostname + "]"
+		}
+	}
+	parsed.Host = host
+	parsed.User = nil
+	parsed.Fragment = ""
+	parsed.RawFragment = ""
+
+	if parsed.Path == "" {
+		parsed.Path = "/"
+	} else {
+		cleaned := path.Clean(parsed.Path)
+		if cleaned != parsed.Path {
+			parsed.Path = cleaned
+			parsed.RawPath = ""
+		}
+	}
+
+	query := parsed.Query()
+	for param := range query {
+		if isTrackingParam(param) {
+			query.Del(param)
+		}
+	}
+	//Encode sorts by key, keeping the order of repeated values
+	parsed.RawQuery = query.Encode()
+	parsed.ForceQuery = false
+
+	return parsed.String(), nil
+}
+
+// Tracking parameters come from config and may end in * to match a prefix such as utm_*
+func isTrackingParam(param string) bool {
+	param = strings.ToLower(param)
+	for _, tracking := range configuration.TrackingParams {
+		tracking = strings.ToLower(tracking)
+		if strings.HasSuffix(tracking, "*") {
+			if strings.HasPrefix(param, strings.TrimSuffix(tracking, "*")) {
+				return true
+			}
+		} else if param == tracking {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether two URLs are on the same host or under the same registrable domain
+func sameSite(a string, b string) bool {
+	hostA, err := hostOf(a)
+	if err != nil {
+		return false
+	}
+	hostB, err := hostOf(b)
+	if err != nil {
+		return false
+	}
+	return hostA == hostB || registrableDomain(hostA) == registrableDomain(hostB)
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	walAdd    = "add"
+	walRemove = "remove"
+	walClear  = "clear"
+	walTouch  = "touch"
+)
+
+const walPrefix = "wal-"
+const walSuffix = ".log"
+
+type walRecord struct {
+	Seq         uint64
+	Op          string
+	Info        indexCacheInfo
+	Counts      map[string]int
+	Positions   map[string][]int
+	Fingerprint uint64
+	Links       []pageLink
+	Language    string
+	Text        []byte
+	Words       []string
+	Metadata    *documentMetadata
+}
+
+// The log is split into segments named after their first sequence number so a snapshot can retire whole files
+type writeAheadLog struct {
+	dir  string
+	file *os.File
+	seq  uint64
+}
+
+// wal is nil when persistence is disabled; it is only touched while holding indexCashMutex
+var wal *writeAheadLog
+
+func walSegmentName(firstSeq uint64) string {
+	return fmt.Sprintf("%s%020d%s", walPrefix, firstSeq, walSuffix)
+}
+
+func listWALSegments(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var segments []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), walPrefix) && strings.HasSuffix(entry.Name(), walSuffix) {
+			segments = append(segments, entry.Name())
+		}
+	}
+	sort.Strings(segments)
+	return segments, nil
+}
+
+// Opens a fresh segment after the given sequence number
+func openWAL(dir string, seq uint64) (*writeAheadLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	l := &writeAheadLog{dir: dir, seq: seq}
+	if err := l.rotate(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Any existing file with the next segment's name can only hold a torn tail, so it is truncated
+func (l *writeAheadLog) rotate() error {
+	if l.file != nil {
+		if err := l.file.Close(); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(filepath.Join(l.dir, walSegmentName(l.seq+1)), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	l.file = file
+	return nil
+}
+
+// Each record is framed as length, checksum, gob payload and synced before returning
+func (l *writeAheadLog) append(record walRecord) error {
+	record.Seq = l.seq + 1
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(record); err != nil {
+		return err
+	}
+	frame := make([]byte, 8, 8+payload.Len())
+	binary.BigEndian.PutUint32(frame, uint32(payload.Len()))
+	binary.BigEndian.PutUint32(frame[4:], crc32.ChecksumIEEE(payload.Bytes()))
+	frame = append(frame, payload.Bytes()...)
+
+	if _, err := l.file.Write(frame); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.seq = record.Seq
+	return nil
+}
+
+func (l *writeAheadLog) close() error {
+	return l.file.Close()
+}
+
+// Removes every segment older than the one currently being written
+func (l *writeAheadLog) retireSegments() error {
+	current := filepath.Base(l.file.Name())
+	segments, err := listWALSegments(l.dir)
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if segment < current {
+			if err := os.Remove(filepath.Join(l.dir, segment)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Reads records until the end of the segment or the first torn or corrupt record left by a crash
+func readWALSegment(path string) ([]walRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []walRecord
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(file, header); err != nil {
+			if err != io.EOF {
+				fmt.Println("Ignoring torn WAL record header in", path)
+			}
+			return records, nil
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header))
+		if _, err := io.ReadFull(file, payload); err != nil || crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+			fmt.Println("Ignoring torn WAL record in", path)
+			return records, nil
+		}
+		var record walRecord
+		if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&record); err != nil {
+			fmt.Println("Ignoring undecodable WAL record in", path)
+			return records, nil
+		}
+		records = append(records, record)
+	}
+}
+
+// Applies every logged record newer than the snapshot and returns the last sequence number seen
+func replayWAL(dir string, afterSeq uint64) (uint64, error) {
+	segments, err := listWALSegments(dir)
+	if err != nil {
+		return afterSeq, err
+	}
+
+	lastSeq := afterSeq
+	replayed := 0
+	indexCashMutex.Lock()
+	defer indexCashMutex.Unlock()
+	for _, segment := range segments {
+		records, err := readWALSegment(filepath.Join(dir, segment))
+		if err != nil {
+			return lastSeq, err
+		}
+		for _, record := range records {
+			if record.Seq <= lastSeq {
+				continue
+			}
+			applyRecord(record)
+			lastSeq = record.Seq
+			replayed++
+		}
+	}
+	if replayed > 0 {
+		fmt.Println("Replayed", replayed, "index changes from the write-ahead log")
+	}
+	return lastSeq, nil
+}
+
+func applyRecord(record walRecord) {
+	switch record.Op {
+	case walAdd:
+		applyDocument(record.Counts, record.Info)
+		applyPositions(record.Positions, record.Info)
+		//The anchor field is analyzed in the page's language
+		if record.Language != "" {
+			documentLanguages[record.Info.URL] = record.Language
+		}
+		if record.Text != nil {
+			documentTexts[record.Info.URL] = record.Text
+		}
+		if record.Words != nil {
+			setDocumentWords(record.Info.URL, record.Words)
+		}
+		if record.Metadata != nil {
+			documentStore[record.Info.URL] = *record.Metadata
+		}
+		applyLinks(record.Info.URL, record.Links)
+		applyAnchorField(record.Info.URL)
+		if record.Fingerprint != 0 {
+			nearDuplicates.add(record.Info.URL, record.Fingerprint)
+		}
+	case walRemove:
+		//Only the URL's current document owns its links
+		current := documentsByURL[record.Info.URL] == record.Info
+		applyRemoveDocument(record.Info)
+		if current {
+			applyLinks(record.Info.URL, nil)
+			delete(documentLanguages, record.Info.URL)
+			delete(documentTexts, record.Info.URL)
+			delete(documentStore, record.Info.URL)
+			setDocumentWords(record.Info.URL, nil)
+		}
+		nearDuplicates.remove(record.Info.URL)
+	case walTouch:
+		//Only the metadata of a page fetched again unchanged is updated
+		if documentsByURL[record.Info.URL] == record.Info && record.Metadata != nil {
+			documentStore[record.Info.URL] = *record.Metadata
+		}
+	case walClear:
+		indexCache = make(map[string]map[indexCacheInfo]int)
+		documentsByURL = make(map[string]indexCacheInfo)
+		documentIDs = make(map[string]string)
+		documentTerms = make(map[indexCacheInfo]map[string]bool)
+		documentStore = make(map[string]documentMetadata)
+		dictionary = newTermDictionary()
+		wordDictionary = newTermDictionary()
+		documentWords = make(map[string][]string)
+		clearDocumentLengths()
+		termPositions = make(map[string]map[indexCacheInfo][]int)
+		clearLinks()
+		pageRanks = make(map[string]float64)
+		documentLanguages = make(map[string]string)
+		documentTexts = make(map[string][]byte)
+		nearDuplicates = newDuplicateIndex(nearDuplicates.maxDistance)
+	}
+}
+
+// Logs the change before applying it so a crash between the two never loses an applied change
+func logAndApply(record walRecord) error {
+	indexCashMutex.Lock()
+	defer indexCashMutex.Unlock()
+	return logAndApplyLocked([]walRecord{record})
+}
+
+// Logs and applies each change in turn. Callers must hold indexCashMutex.
+func logAndApplyLocked(records []walRecord) error {
+	for _, record := range records {
+		if wal != nil {
+			if err := wal.append(record); err != nil {
+				return err
+			}
+		}
+		applyRecord(record)
+	}
+	return nil
+}
+
+// Drops whatever was indexed for the document's URL before adding it, so words no longer on the page
+// and copies under an old title do not linger
+func replaceDocument(document walRecord) error {
+	indexCashMutex.Lock()
+	defer indexCashMutex.Unlock()
+	document.Op = walAdd
+	records := []walRecord{document}
+	if previous, ok := documentsByURL[document.Info.URL]; ok {
+		records = append([]walRecord{{Op: walRemove, Info: previous}}, records...)
+	}
+	return logAndApplyLocked(records)
+}
+
+func removeDocument(info indexCacheInfo) error {
+	return logAndApply(walRecord{Op: walRemove, Info: info})
+}
+
+func clearIndex() error {
+	return logAndApply(walRecord{Op: walClear})
+}
+
+// Loads the latest snapshot, replays the log on top of it and starts logging new changes
+func openIndex(dir string) error {
+	snapshotSeq, err := loadSnapshot(dir)
+	if err != nil {
+		return err
+	}
+	lastSeq, err := replayWAL(dir, snapshotSeq)
+	if err != nil {
+		return err
+	}
+
+	opened, err := openWAL(dir, lastSeq)
+	if err != nil {
+		return err
+	}
+	indexCashMutex.Lock()
+	wal = opened
+	indexCashMutex.Unlock()
+	return nil
+}
+
+func closeIndex() error {
+	indexCashMutex.Lock()
+	defer indexCashMutex.Unlock()
+	if wal == nil {
+		return nil
+	}
+	err := wal.close()
+	wal = nil
+	return err
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWALReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kgp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	indexCache = map[string]map[indexCacheInfo]int{}
+	if err := openIndex(dir); err != nil {
+		t.Fatal(err)
+	}
+	page1 := indexCacheInfo{"Test Title 1", "test.com/1"}
+	page2 := indexCacheInfo{"Test Title 2", "test.com/2"}
+	page3 := indexCacheInfo{"Test Title 3", "test.com/3"}
+
+	updateCache(map[string]int{"a": 2, "b": 1}, page1)
+	if err := saveSnapshot(dir); err != nil {
+		t.Fatal(err)
+	}
+	updateCache(map[string]int{"a": 1, "c": 1}, page2)
+	updateCache(map[string]int{"c": 4}, page3)
+	removeDocument(page2)
+	expected := map[string]map[indexCacheInfo]int{"a": {page1: 2}, "b": {page1: 1}, "c": {page3: 4}}
+	if !reflect.DeepEqual(indexCache, expected) {
+		t.Fatal("cache: ", indexCache, "does not match expected", expected)
+	}
+	if err := closeIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, _ := listWALSegments(dir)
+	if len(segments) != 1 {
+		t.Error("Expected the snapshot to retire older WAL segments but found", segments)
+	}
+
+	indexCache = map[string]map[indexCacheInfo]int{}
+	if err := openIndex(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer closeIndex()
+	if !reflect.DeepEqual(indexCache, expected) {
+		t.Error("recovered cache: ", indexCache, "does not match expected", expected)
+	}
+}
+
+func TestWALIgnoresTornRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kgp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	segmentLog, err := openWAL(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page1 := indexCacheInfo{"Test Title 1", "test.com/1"}
+	segmentLog.append(walRecord{Op: walAdd, Info: page1, Counts: map[string]int{"a": 1}})
+	segmentLog.append(walRecord{Op: walAdd, Info: page1, Counts: map[string]int{"a": 2}})
+	segmentLog.close()
+
+	path := filepath.Join(dir, walSegmentName(1))
+	data, _ := ioutil.ReadFile(path)
+	ioutil.WriteFile(path, data[:len(data)-3], 0644)
+
+	records, err := readWALSegment(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Seq != 1 || records[0].Counts["a"] != 1 {
+		t.Error("Expected only the first complete record but received", records)
+	}
+}
+
+// Runs a crawl in a child process that is killed part way through; only used by TestWALCrashRecovery
+func TestWALCrashHelper(t *testing.T) {
+	dir := os.Getenv("KGP_WAL_CRASH_DIR")
+	if dir == "" {
+		t.Skip("Only run as a child of TestWALCrashRecovery")
+	}
+	configuration.MaxDepth = 100
+	configuration.MaxParallel = 1
+	if err := openIndex(dir); err != nil {
+		t.Fatal(err)
+	}
+	job := newCrawlJob(crawlRequest{URL: os.Getenv("KGP_WAL_CRASH_URL")})
+	runCrawlJob(job)
+	t.Fatal("The crawl should have been killed before finishing")
+}
+
+func TestWALCrashRecovery(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping crash recovery in short mode")
+	}
+	dir, err := ioutil.TempDir("", "kgp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//Pages link in a chain; the fourth never responds so the child is always mid-crawl when killed
+	hang := make(chan struct{})
+	defer close(hang)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		page, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		if page >= 4 {
+			select {
+			case <-hang:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		fmt.Fprintf(w, "<head><Title>Page %d</Title></head>word%s <a href=\"/%d\">Next</a>", page, strings.Repeat("x", page), page+1)
+	}))
+	defer server.Close()
+
+	child := exec.Command(os.Args[0], "-test.run=^TestWALCrashHelper$")
+	child.Env = append(os.Environ(), "KGP_WAL_CRASH_DIR="+dir, "KGP_WAL_CRASH_URL="+server.URL+"/1")
+	if err := child.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		segments, _ := listWALSegments(dir)
+		logged := 0
+		for _, segment := range segments {
+			records, _ := readWALSegment(filepath.Join(dir, segment))
+			logged += len(records)
+		}
+		if logged >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			child.Process.Kill()
+			t.Fatal("Child crawl did not log three pages in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	child.Process.Kill()
+	child.Wait()
+
+	indexCache = map[string]map[indexCacheInfo]int{}
+	if err := openIndex(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer closeIndex()
+	for page := 1; page <= 3; page++ {
+		word := "word" + strings.Repeat("x", page)
+		info := indexCacheInfo{fmt.Sprintf("Page %d", page), fmt.Sprintf("%s/%d", server.URL, page)}
+		if indexCache[word][info] != 1 {
+			t.Error("Expected", word, "from", info, "to be recovered but found", indexCache[word])
+		}
+	}
+}